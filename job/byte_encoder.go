@@ -34,7 +34,10 @@ func encodeBytes(bs []byte) []byte {
 	return bs
 }
 
-func decodeBytes(bs []byte) []byte {
+// decodeBytes unescapes tabs, new lines and backslashes. Invalid escape sequences are decoded by dropping the backslash
+// and ErrInvalidLine is returned along with the decoded bytes.
+func decodeBytes(bs []byte) ([]byte, error) {
+	var err error
 	copied := false
 
 	for i := 0; i < len(bs); i++ {
@@ -48,13 +51,19 @@ func decodeBytes(bs []byte) []byte {
 			copied = true
 		}
 		bs = append(bs[:i], bs[i+1:]...)
+		if i == len(bs) {
+			// trailing backslash
+			return bs, ErrInvalidLine
+		}
 		if bs[i] == 't' {
 			bs[i] = '\t'
 		} else if bs[i] == 'n' {
 			bs[i] = '\n'
+		} else if bs[i] != '\\' {
+			err = ErrInvalidLine
 		}
 	}
-	return bs
+	return bs, err
 }
 
 type encodeWriter struct {
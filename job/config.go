@@ -6,11 +6,36 @@ import (
 	"os"
 )
 
+const (
+	// ConfigEnv is the environment variable holding the json encoded job config.
+	ConfigEnv = "mrgob_config"
+	// InputFileEnv is the environment variable set by hadoop streaming to the name of the mapper input file.
+	InputFileEnv = "mapreduce_map_input_file"
+	// InputStartEnv and InputLengthEnv are set to the byte range of the mapper input split.
+	InputStartEnv  = "mapreduce_map_input_start"
+	InputLengthEnv = "mapreduce_map_input_length"
+	// TaskPartitionEnv is set to the index of the mapper or the reducer task.
+	TaskPartitionEnv = "mapreduce_task_partition"
+	// TaskIsMapEnv is set to true in mappers and false in reducers.
+	TaskIsMapEnv = "mapreduce_task_ismap"
+	// TaskAttemptEnv is set to the id of the task attempt, e.g. attempt_1459425323333_0001_m_000003_0.
+	TaskAttemptEnv = "mapreduce_task_attempt_id"
+)
+
 var ErrMissingJobConfig = fmt.Errorf("Missing job config")
 
+// EncodeConfig encodes the job config into the value of the ConfigEnv environment variable.
+func EncodeConfig(config interface{}) (string, error) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
 // Config retrieves and decodes the job config passed from the runner.
 func Config(target interface{}) error {
-	cstr := os.Getenv("mrgob_config")
+	cstr := os.Getenv(ConfigEnv)
 	if cstr == "" {
 		return ErrMissingJobConfig
 	}
@@ -2,7 +2,10 @@ package job
 
 import (
 	"fmt"
+	"io"
 	"os"
+
+	"github.com/Zemanta/mrgob/job/internal/hooks"
 )
 
 var AppCounterGroup = "GOMR"
@@ -12,8 +15,24 @@ var counterMsg = "reporter:counter:%s,%s,%d\n"
 
 // Count increases hadoop counter for the running job. Use it for counting processed lines, errors etc.
 func Count(name string, c int) {
+	countGroup(AppCounterGroup, name, c)
+}
+
+// counterWriter returns the writer counters are reported to or nil if they aren't reported.
+func counterWriter() io.Writer {
+	if hooks.CounterWriter != nil {
+		return hooks.CounterWriter
+	}
 	if CounterPipe == nil {
+		return nil
+	}
+	return CounterPipe
+}
+
+func countGroup(group, name string, c int) {
+	w := counterWriter()
+	if w == nil {
 		return
 	}
-	fmt.Fprintf(CounterPipe, counterMsg, AppCounterGroup, name, c)
+	fmt.Fprintf(w, counterMsg, group, name, c)
 }
@@ -0,0 +1,275 @@
+package job
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// Description is the machine readable metadata printed by job binaries when run with --stage=describe.
+type Description struct {
+	Jobs  []*JobDescription `json:"jobs"`
+	Build *BuildDescription `json:"build,omitempty"`
+}
+
+// JobDescription describes a single job in the binary. Jobs started with Init*Job have an empty name.
+type JobDescription struct {
+	Name string `json:"name"`
+
+	// Codec used by each stage of the job.
+	Stages   map[string]string `json:"stages"`
+	Combiner bool              `json:"combiner"`
+
+	// Schema of the config set with Job.WithConfig.
+	Config *ConfigSchema `json:"config,omitempty"`
+}
+
+// BuildDescription holds the build info embedded in the binary by the go tool.
+type BuildDescription struct {
+	GoVersion string `json:"goVersion"`
+	Path      string `json:"path"`
+	Version   string `json:"version"`
+
+	Revision string `json:"revision,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Modified bool   `json:"modified"`
+}
+
+// ConfigSchema describes the json representation of a job config.
+type ConfigSchema struct {
+	// One of object, map, array, string, number, integer, boolean or any.
+	Type string `json:"type"`
+
+	// Fields of an object.
+	Fields map[string]*ConfigSchema `json:"fields,omitempty"`
+	// Elements of an array or a map.
+	Elem *ConfigSchema `json:"elem,omitempty"`
+}
+
+// Job returns the description of the named job.
+func (d *Description) Job(name string) (*JobDescription, error) {
+	for _, jd := range d.Jobs {
+		if jd.Name == name {
+			return jd, nil
+		}
+	}
+	return nil, ErrUnknownJob
+}
+
+// Describe returns the description of all the registered jobs.
+func Describe() *Description {
+	d := &Description{
+		Jobs:  []*JobDescription{},
+		Build: describeBuild(),
+	}
+	for _, name := range Registered() {
+		d.Jobs = append(d.Jobs, registry[name].describe(name))
+	}
+	return d
+}
+
+func (j *Job) describe(name string) *JobDescription {
+	jd := &JobDescription{
+		Name: name,
+		Stages: map[string]string{
+			"mapper":  j.codec,
+			"reducer": j.codec,
+		},
+		Combiner: j.HasCombiner(),
+	}
+	if jd.Combiner {
+		jd.Stages["combiner"] = j.codec
+	}
+	if j.config != nil {
+		jd.Config = newConfigSchema(reflect.TypeOf(j.config), map[reflect.Type]bool{})
+	}
+	return jd
+}
+
+func describeBuild() *BuildDescription {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+
+	b := &BuildDescription{
+		GoVersion: info.GoVersion,
+		Path:      info.Path,
+		Version:   info.Main.Version,
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.Time = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+func writeDescription(w io.Writer, d *Description) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func newConfigSchema(t reflect.Type, visiting map[reflect.Type]bool) *ConfigSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types with custom decoding and recursive types can't be described
+	if visiting[t] || reflect.PtrTo(t).Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return &ConfigSchema{Type: "any"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &ConfigSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &ConfigSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &ConfigSchema{Type: "number"}
+	case reflect.String:
+		return &ConfigSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &ConfigSchema{Type: "string"}
+		}
+		return &ConfigSchema{Type: "array", Elem: newConfigSchema(t.Elem(), visiting)}
+	case reflect.Map:
+		return &ConfigSchema{Type: "map", Elem: newConfigSchema(t.Elem(), visiting)}
+	case reflect.Struct:
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		s := &ConfigSchema{Type: "object", Fields: map[string]*ConfigSchema{}}
+		addConfigFields(s, t, visiting)
+		return s
+	}
+
+	return &ConfigSchema{Type: "any"}
+}
+
+func addConfigFields(s *ConfigSchema, t reflect.Type, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			addConfigFields(s, ft, visiting)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		s.Fields[name] = newConfigSchema(f.Type, visiting)
+	}
+}
+
+func (s *ConfigSchema) field(name string) (*ConfigSchema, bool) {
+	if fs, ok := s.Fields[name]; ok {
+		return fs, true
+	}
+	// encoding/json matches field names case insensitively
+	for fn, fs := range s.Fields {
+		if strings.EqualFold(fn, name) {
+			return fs, true
+		}
+	}
+	return nil, false
+}
+
+// Validate checks whether the decoded json value matches the schema.
+func (s *ConfigSchema) Validate(v interface{}) error {
+	return s.validate("config", v)
+}
+
+func (s *ConfigSchema) validate(path string, v interface{}) error {
+	if s.Type == "any" || v == nil {
+		return nil
+	}
+
+	mismatch := fmt.Errorf("Invalid config value %s: expected %s", path, s.Type)
+
+	switch s.Type {
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return mismatch
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return mismatch
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return mismatch
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+			return mismatch
+		}
+	case "array":
+		vs, ok := v.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		for i, ev := range vs {
+			if err := s.Elem.validate(fmt.Sprintf("%s[%d]", path, i), ev); err != nil {
+				return err
+			}
+		}
+	case "map", "object":
+		vs, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		keys := make([]string, 0, len(vs))
+		for k := range vs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			es := s.Elem
+			if s.Type == "object" {
+				if es, ok = s.field(k); !ok {
+					return fmt.Errorf("Unknown config field %s.%s", path, k)
+				}
+			}
+			if err := es.validate(path+"."+k, vs[k]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
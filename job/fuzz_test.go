@@ -0,0 +1,149 @@
+package job
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Zemanta/mrgob/job/internal/sorter"
+)
+
+type fuzzRecord struct {
+	key, value string
+}
+
+// fuzzRecords returns records with repeated and different keys so the reader has to group values.
+func fuzzRecords(k1, v1, k2, v2 string) []fuzzRecord {
+	return []fuzzRecord{{k1, v1}, {k2, v2}, {k1, v2}, {k2, v1}}
+}
+
+// expectedGroups returns values of each key in the order they were written.
+func expectedGroups(records []fuzzRecord) map[string][]string {
+	groups := map[string][]string{}
+	for _, r := range records {
+		groups[r.key] = append(groups[r.key], r.value)
+	}
+	return groups
+}
+
+func checkGroups(t *testing.T, records []fuzzRecord, keys []string, values [][]string) {
+	expected := expectedGroups(records)
+	if len(keys) != len(expected) {
+		t.Fatalf("Invalid number of keys: %q != %d", keys, len(expected))
+	}
+	for i, k := range keys {
+		if fmt.Sprintf("%q", values[i]) != fmt.Sprintf("%q", expected[k]) {
+			t.Fatalf("Invalid values of key %q: %q != %q", k, values[i], expected[k])
+		}
+	}
+}
+
+func sortLines(t *testing.T, data []byte) []byte {
+	s := sorter.New(nil)
+	defer s.Close()
+
+	s.Write(data)
+	r, err := s.Sort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func addCodecSeeds(f *testing.F) {
+	f.Add("key", "value", "other", "")
+	f.Add("", "", "a", "b")
+	f.Add("a\tb", "c\td", "a\\tb", "c\\td")
+	f.Add("a\nb", "\\", "a\\nb", "\\\\n")
+	f.Add("\\", "\t", "\\\\", "\n")
+}
+
+func FuzzByteCodec(f *testing.F) {
+	addCodecSeeds(f)
+
+	f.Fuzz(func(t *testing.T, k1, v1, k2, v2 string) {
+		records := fuzzRecords(k1, v1, k2, v2)
+
+		buf := &bytes.Buffer{}
+		w := NewByteKVWriter(buf)
+		for _, r := range records {
+			if err := w.Write([]byte(r.key), []byte(r.value)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Flush()
+
+		keys := []string{}
+		values := [][]string{}
+		r := NewByteKVReader(bytes.NewReader(sortLines(t, buf.Bytes())))
+		for r.Scan() {
+			key, vr := r.Key()
+			vs := []string{}
+			for vr.Scan() {
+				vs = append(vs, string(vr.Value()))
+			}
+			keys = append(keys, string(key))
+			values = append(values, vs)
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		checkGroups(t, records, keys, values)
+	})
+}
+
+func FuzzJsonCodec(f *testing.F) {
+	addCodecSeeds(f)
+
+	f.Fuzz(func(t *testing.T, k1, v1, k2, v2 string) {
+		// invalid utf-8 is replaced by the json encoder
+		for _, s := range []string{k1, v1, k2, v2} {
+			if !utf8.ValidString(s) {
+				t.Skip()
+			}
+		}
+		records := fuzzRecords(k1, v1, k2, v2)
+
+		buf := &bytes.Buffer{}
+		w := NewJsonKVWriter(buf)
+		for _, r := range records {
+			if err := w.Write(r.key, r.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Flush()
+
+		keys := []string{}
+		values := [][]string{}
+		r := NewJsonKVReader(bytes.NewReader(sortLines(t, buf.Bytes())))
+		for r.Scan() {
+			var key string
+			vr, err := r.Key(&key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vs := []string{}
+			for vr.Scan() {
+				var v string
+				if err := vr.Value(&v); err != nil {
+					t.Fatal(err)
+				}
+				vs = append(vs, v)
+			}
+			keys = append(keys, key)
+			values = append(values, vs)
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		checkGroups(t, records, keys, values)
+	})
+}
@@ -3,60 +3,133 @@ package job
 import (
 	"flag"
 	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 )
 
-func initStage() string {
-	var runStage = flag.String("stage", "", "specify the stage to run.  Can be 'mapper' or 'reducer'")
+type initFlags struct {
+	stage string
+	job   string
+
+	input      string
+	output     string
+	config     string
+	sortMemory int
+	tempDir    string
+}
+
+func parseFlags() *initFlags {
+	f := &initFlags{}
+	flag.StringVar(&f.stage, "stage", "", "specify the stage to run.  Can be 'mapper', 'combiner', 'reducer', 'local' or 'describe'")
+	flag.StringVar(&f.job, "job", "", "specify the registered job to run")
+	flag.StringVar(&f.input, "input", "", "comma separated list of input files or glob patterns for the local stage")
+	flag.StringVar(&f.output, "output", "", "output directory for the local stage")
+	flag.StringVar(&f.config, "config", "", "json encoded job config for the local stage, prefix with @ to read it from a file")
+	flag.IntVar(&f.sortMemory, "sort-memory", 256, "memory in MB used for sorting in the local stage before spilling to disk")
+	flag.StringVar(&f.tempDir, "tmp", "", "directory for temporary sort files of the local stage")
 	flag.Parse()
 
-	if *runStage == "" {
+	if f.stage == "" {
 		flag.PrintDefaults()
-		return ""
 	}
 
-	return *runStage
+	return f
 }
 
-// InitRawJob initiates a raw mapreduce job, calling an appropriate function based on the mapreduce stage
-func InitRawJob(mapper func(io.Writer, io.Reader), reducer func(io.Writer, io.Reader)) {
-	switch initStage() {
-	case "mapper":
-		mapper(os.Stdout, os.Stdin)
-	case "reducer":
-		reducer(os.Stdout, os.Stdin)
-	default:
-		Log.Fatalln("stage must be either 'mapper' or 'reducer'")
+func (f *initFlags) localConfig() (*LocalConfig, error) {
+	cfg := &LocalConfig{
+		Output:     f.output,
+		Config:     f.config,
+		SortMemory: f.sortMemory << 20,
+		TempDir:    f.tempDir,
 	}
-	os.Stdout.Sync()
+	if f.input != "" {
+		cfg.Input = strings.Split(f.input, ",")
+	}
+	if strings.HasPrefix(f.config, "@") {
+		b, err := ioutil.ReadFile(f.config[1:])
+		if err != nil {
+			return nil, err
+		}
+		cfg.Config = string(b)
+	}
+	return cfg, nil
 }
 
-// InitByteJob initiates a byte reader/writer mapreduce job, calling an appropriate function based on the mapreduce stage
-func InitByteJob(mapper func(*ByteKVWriter, io.Reader), reducer func(io.Writer, *ByteKVReader)) {
-	switch initStage() {
-	case "mapper":
-		w := NewByteKVWriter(os.Stdout)
-		mapper(w, os.Stdin)
-		w.Flush()
-	case "reducer":
-		reducer(os.Stdout, NewByteKVReader(os.Stdin))
-	default:
-		Log.Fatalln("stage must be either 'mapper' or 'reducer'")
+func describeJob(d *Description) {
+	if err := writeDescription(os.Stdout, d); err != nil {
+		Log.Fatal(err)
 	}
 	os.Stdout.Sync()
 }
 
-// InitByteJob initiates a json reader/writer mapreduce job, calling an appropriate function based on the mapreduce stage
-func InitJsonJob(mapper func(*JsonKVWriter, io.Reader), reducer func(io.Writer, *JsonKVReader)) {
-	switch initStage() {
-	case "mapper":
-		w := NewJsonKVWriter(os.Stdout)
-		mapper(w, os.Stdin)
-		w.Flush()
-	case "reducer":
-		reducer(os.Stdout, NewJsonKVReader(os.Stdin))
-	default:
-		Log.Fatalln("stage must be either 'mapper' or 'reducer'")
+func runJob(j *Job, f *initFlags) {
+	switch f.stage {
+	case "describe":
+		describeJob(&Description{
+			Jobs:  []*JobDescription{j.describe("")},
+			Build: describeBuild(),
+		})
+		return
+	case "local":
+		cfg, err := f.localConfig()
+		if err != nil {
+			Log.Fatal(err)
+		}
+		if err := j.RunLocal(cfg); err != nil {
+			Log.Fatal(err)
+		}
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	var r io.Reader = os.Stdin
+
+	var stats *taskStats
+	if statsEnabled() {
+		stats = newTaskStats(w, r)
+		w, r = stats.out, stats.in
+	}
+
+	if err := j.Run(f.stage, w, r); err == ErrUnknownStage {
+		Log.Fatalln("stage must be either 'mapper', 'combiner', 'reducer', 'local' or 'describe'")
 	}
 	os.Stdout.Sync()
+
+	if stats != nil {
+		stats.report(f.stage)
+	}
+}
+
+// Init runs a job registered with Register, calling an appropriate function based on the mapreduce stage and the --job flag
+func Init() {
+	f := parseFlags()
+
+	if f.stage == "describe" {
+		describeJob(Describe())
+		return
+	}
+
+	j, err := Lookup(f.job)
+	if err != nil {
+		Log.Fatalf("job must be one of: %s", strings.Join(Registered(), ", "))
+	}
+
+	runJob(j, f)
+}
+
+// InitRawJob initiates a raw mapreduce job, calling an appropriate function based on the mapreduce stage
+func InitRawJob(mapper func(io.Writer, io.Reader), reducer func(io.Writer, io.Reader)) {
+	runJob(NewRawJob(mapper, reducer), parseFlags())
+}
+
+// InitByteJob initiates a byte reader/writer mapreduce job, calling an appropriate function based on the mapreduce stage
+func InitByteJob(mapper func(*ByteKVWriter, io.Reader), reducer func(io.Writer, *ByteKVReader)) {
+	runJob(NewByteJob(mapper, reducer), parseFlags())
+}
+
+// InitJsonJob initiates a json reader/writer mapreduce job, calling an appropriate function based on the mapreduce stage
+func InitJsonJob(mapper func(*JsonKVWriter, io.Reader), reducer func(io.Writer, *JsonKVReader)) {
+	runJob(NewJsonJob(mapper, reducer), parseFlags())
 }
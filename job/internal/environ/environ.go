@@ -0,0 +1,17 @@
+// Package environ sets environment variables of simulated tasks.
+package environ
+
+import "os"
+
+// Set sets the environment variable and returns a function restoring its previous value.
+func Set(k, v string) func() {
+	prev, ok := os.LookupEnv(k)
+	os.Setenv(k, v)
+	return func() {
+		if ok {
+			os.Setenv(k, prev)
+		} else {
+			os.Unsetenv(k)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+// Package hooks holds process wide hooks the job tester uses to capture the output of jobs.
+package hooks
+
+import "io"
+
+// CounterWriter receives counters instead of job.CounterPipe when set.
+var CounterWriter io.Writer
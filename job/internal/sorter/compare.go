@@ -0,0 +1,156 @@
+package sorter
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type keySpec struct {
+	start, end int
+	numeric    bool
+	reverse    bool
+}
+
+// ParseKeyComparatorOptions parses hadoop KeyFieldBasedComparator options (mapreduce.partition.keycomparator.options),
+// e.g. "-k1,1 -k2,2nr", and returns a function comparing keys which are split into fields by the separator.
+// Supported options are -n, -r and -kPOS1[,POS2] where positions are field numbers optionally followed by n and r.
+func ParseKeyComparatorOptions(options string, sep byte) (func(a, b []byte) int, error) {
+	var numeric, reverse bool
+	specs := []*keySpec{}
+
+	for _, opt := range strings.Fields(options) {
+		switch {
+		case opt == "-n":
+			numeric = true
+		case opt == "-r":
+			reverse = true
+		case opt == "-nr" || opt == "-rn":
+			numeric, reverse = true, true
+		case strings.HasPrefix(opt, "-k"):
+			ks, err := parseKeySpec(opt[2:])
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, ks)
+		default:
+			return nil, fmt.Errorf("Unsupported key comparator option: %s", opt)
+		}
+	}
+
+	// global options apply to keys without their own options
+	if len(specs) == 0 {
+		specs = append(specs, &keySpec{start: 1})
+	}
+	for _, ks := range specs {
+		if !ks.numeric && !ks.reverse {
+			ks.numeric, ks.reverse = numeric, reverse
+		}
+	}
+
+	return func(a, b []byte) int {
+		fa := bytes.Split(a, []byte{sep})
+		fb := bytes.Split(b, []byte{sep})
+		for _, ks := range specs {
+			c := ks.compare(ks.fields(fa, sep), ks.fields(fb, sep))
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	}, nil
+}
+
+func parseKeyPos(pos string) (int, string, error) {
+	i := 0
+	for i < len(pos) && pos[i] >= '0' && pos[i] <= '9' {
+		i++
+	}
+	if i < len(pos) && pos[i] == '.' {
+		return 0, "", fmt.Errorf("Character positions aren't supported in key comparator options")
+	}
+	n, err := strconv.Atoi(pos[:i])
+	if err != nil {
+		return 0, "", fmt.Errorf("Invalid key position: %s", pos)
+	}
+	return n, pos[i:], nil
+}
+
+func parseKeySpec(spec string) (*keySpec, error) {
+	ks := &keySpec{}
+	parts := strings.SplitN(spec, ",", 2)
+
+	for i, part := range parts {
+		n, flags, err := parseKeyPos(part)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			ks.start = n
+		} else {
+			ks.end = n
+		}
+		for _, f := range flags {
+			switch f {
+			case 'n':
+				ks.numeric = true
+			case 'r':
+				ks.reverse = true
+			default:
+				return nil, fmt.Errorf("Unsupported key comparator flag: %c", f)
+			}
+		}
+	}
+
+	if ks.start < 1 || ks.end != 0 && ks.end < ks.start {
+		return nil, fmt.Errorf("Invalid key fields: %s", spec)
+	}
+	return ks, nil
+}
+
+// fields returns the fields of the key selected by the spec, joined with the separator.
+func (ks *keySpec) fields(fs [][]byte, sep byte) []byte {
+	start := ks.start - 1
+	end := len(fs)
+	if ks.end > 0 && ks.end < end {
+		end = ks.end
+	}
+	if start >= end {
+		return nil
+	}
+	return bytes.Join(fs[start:end], []byte{sep})
+}
+
+func (ks *keySpec) compare(a, b []byte) int {
+	var c int
+	if ks.numeric {
+		c = compareNumeric(a, b)
+	} else {
+		c = bytes.Compare(a, b)
+	}
+	if ks.reverse {
+		return -c
+	}
+	return c
+}
+
+// compareNumeric compares the values as numbers. Values which aren't numbers sort before numbers.
+func compareNumeric(a, b []byte) int {
+	na, erra := strconv.ParseFloat(string(bytes.TrimSpace(a)), 64)
+	nb, errb := strconv.ParseFloat(string(bytes.TrimSpace(b)), 64)
+
+	switch {
+	case erra != nil && errb != nil:
+		return bytes.Compare(a, b)
+	case erra != nil:
+		return -1
+	case errb != nil:
+		return 1
+	case na < nb:
+		return -1
+	case na > nb:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,379 @@
+// Package sorter implements the shuffle sort of mapper output lines with an external merge sort which spills to temporary files.
+package sorter
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+var ErrSorted = fmt.Errorf("Sorter can't be written to after sorting")
+
+var (
+	defaultMemoryLimit = 256 << 20
+
+	// Estimated memory overhead of each buffered line
+	lineOverhead = 32
+)
+
+// Config configures the sorter. The fields mirror the hadoop streaming settings.
+type Config struct {
+	// Maximum number of bytes buffered in memory before the lines are sorted and spilled to a temporary file. Defaults to 256MB.
+	MemoryLimit int
+	// Directory for the temporary files. Defaults to os.TempDir().
+	TempDir string
+
+	// Separator between the fields of a line (stream.map.output.field.separator). Defaults to a tab.
+	Separator byte
+	// Number of leading fields forming the key (stream.num.map.output.key.fields). Defaults to 1.
+	KeyFields int
+	// Compare compares two keys. Defaults to a byte-wise comparison.
+	Compare func(a, b []byte) int
+
+	// Shuffle randomizes the order of lines with equal keys in each sorted chunk when set, instead of keeping the order in which they were written.
+	Shuffle *rand.Rand
+}
+
+func (c *Config) separator() byte {
+	if c == nil || c.Separator == 0 {
+		return '\t'
+	}
+	return c.Separator
+}
+
+func (c *Config) keyFields() int {
+	if c == nil || c.KeyFields <= 0 {
+		return 1
+	}
+	return c.KeyFields
+}
+
+// Split splits the line into the key and the value. Lines with fewer fields than the number of key fields have an empty value.
+func (c *Config) Split(line []byte) (key, value []byte, ok bool) {
+	sep := c.separator()
+	pos := 0
+	for i := c.keyFields(); i > 0; i-- {
+		idx := bytes.IndexByte(line[pos:], sep)
+		if idx < 0 {
+			return line, nil, false
+		}
+		pos += idx + 1
+	}
+	return line[:pos-1], line[pos:], true
+}
+
+// Key returns the key of the line.
+func (c *Config) Key(line []byte) []byte {
+	key, _, _ := c.Split(line)
+	return key
+}
+
+func (c *Config) isDefault() bool {
+	return c.separator() == '\t' && c.keyFields() == 1
+}
+
+// Sorter collects lines written to it and sorts them by key. Lines with equal keys keep the order in which they were written.
+// Sorted lines are rewritten to the key and the value separated by a tab the same way as hadoop streaming passes them to the reducer,
+// so lines without a value end with a tab.
+type Sorter struct {
+	cfg         Config
+	memoryLimit int
+
+	partial []byte
+	lines   [][]byte
+	size    int
+
+	runs   []string
+	files  []*os.File
+	sorted bool
+	err    error
+}
+
+// New creates a new sorter. The config can be nil.
+func New(cfg *Config) *Sorter {
+	s := &Sorter{
+		memoryLimit: defaultMemoryLimit,
+	}
+	if cfg != nil {
+		s.cfg = *cfg
+		if cfg.MemoryLimit > 0 {
+			s.memoryLimit = cfg.MemoryLimit
+		}
+	}
+	if s.cfg.Compare == nil {
+		s.cfg.Compare = bytes.Compare
+	}
+	return s
+}
+
+func (s *Sorter) compareLines(a, b []byte) int {
+	return s.cfg.Compare(s.cfg.Key(a), s.cfg.Key(b))
+}
+
+// reduceLine returns the line in the reducer input format. Hadoop streaming always separates the key and the value with a tab,
+// so lines without a value end with a tab.
+func (s *Sorter) reduceLine(line []byte) []byte {
+	key, value, ok := s.cfg.Split(line)
+	if !ok {
+		return append(line[:len(line):len(line)], '\t')
+	}
+	if s.cfg.isDefault() {
+		return line
+	}
+	out := make([]byte, 0, len(line)+1)
+	out = append(out, key...)
+	out = append(out, '\t')
+	return append(out, value...)
+}
+
+// Write splits the data into lines and buffers them, spilling sorted lines to disk when the memory limit is reached.
+func (s *Sorter) Write(p []byte) (int, error) {
+	if s.sorted {
+		return 0, ErrSorted
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			s.partial = append(s.partial, p...)
+			break
+		}
+
+		var line []byte
+		if len(s.partial) > 0 {
+			line = append(s.partial, p[:i]...)
+			s.partial = nil
+		} else {
+			line = append([]byte(nil), p[:i]...)
+		}
+		p = p[i+1:]
+
+		if err := s.add(line); err != nil {
+			s.err = err
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
+
+func (s *Sorter) add(line []byte) error {
+	s.lines = append(s.lines, line)
+	s.size += len(line) + lineOverhead
+
+	if s.size >= s.memoryLimit {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *Sorter) sortLines() {
+	if s.cfg.Shuffle != nil {
+		s.cfg.Shuffle.Shuffle(len(s.lines), func(i, j int) {
+			s.lines[i], s.lines[j] = s.lines[j], s.lines[i]
+		})
+	}
+	sort.SliceStable(s.lines, func(i, j int) bool {
+		return s.compareLines(s.lines[i], s.lines[j]) < 0
+	})
+}
+
+func (s *Sorter) spill() error {
+	s.sortLines()
+
+	f, err := ioutil.TempFile(s.cfg.TempDir, "mrgob-sort-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+
+	w := bufio.NewWriter(f)
+	for _, line := range s.lines {
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	s.lines = nil
+	s.size = 0
+	return nil
+}
+
+// Sort sorts all the written lines and returns a reader streaming them in order.
+// An unterminated last line is treated as a complete line. Write errors that happened while spilling are returned here as well.
+func (s *Sorter) Sort() (io.Reader, error) {
+	if s.sorted {
+		return nil, ErrSorted
+	}
+	s.sorted = true
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	if len(s.partial) > 0 {
+		s.lines = append(s.lines, s.partial)
+		s.partial = nil
+	}
+
+	if len(s.runs) == 0 {
+		s.sortLines()
+		lines := s.lines
+		s.lines = nil
+		return &lineReader{next: func() ([]byte, error) {
+			if len(lines) == 0 {
+				return nil, io.EOF
+			}
+			line := lines[0]
+			lines = lines[1:]
+			return s.reduceLine(line), nil
+		}}, nil
+	}
+
+	if len(s.lines) > 0 {
+		if err := s.spill(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.merge()
+}
+
+// Close removes all the temporary files.
+func (s *Sorter) Close() error {
+	var err error
+	for _, f := range s.files {
+		f.Close()
+	}
+	for _, fn := range s.runs {
+		if rerr := os.Remove(fn); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	s.files = nil
+	s.runs = nil
+	s.lines = nil
+	return err
+}
+
+type run struct {
+	idx    int
+	reader *bufio.Reader
+	line   []byte
+}
+
+func (r *run) advance() error {
+	line, err := r.reader.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	r.line = line[:len(line)-1]
+	return nil
+}
+
+type runHeap struct {
+	runs    []*run
+	compare func(a, b []byte) int
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool {
+	c := h.compare(h.runs[i].line, h.runs[j].line)
+	return c < 0 || c == 0 && h.runs[i].idx < h.runs[j].idx
+}
+func (h *runHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*run)) }
+func (h *runHeap) Pop() interface{} {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
+
+func (s *Sorter) merge() (io.Reader, error) {
+	h := &runHeap{compare: s.compareLines}
+	for i, fn := range s.runs {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		s.files = append(s.files, f)
+
+		r := &run{idx: i, reader: bufio.NewReader(f)}
+		if err := r.advance(); err == io.EOF {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		h.runs = append(h.runs, r)
+	}
+	heap.Init(h)
+
+	var last *run
+	return &lineReader{next: func() ([]byte, error) {
+		// advance the run of the previously returned line only now as the line is still being read
+		if last != nil {
+			if err := last.advance(); err == io.EOF {
+				heap.Pop(h)
+			} else if err != nil {
+				return nil, err
+			} else {
+				heap.Fix(h, 0)
+			}
+			last = nil
+		}
+
+		if h.Len() == 0 {
+			return nil, io.EOF
+		}
+		last = h.runs[0]
+		return s.reduceLine(last.line), nil
+	}}, nil
+}
+
+// lineReader streams lines returned by next, terminating each of them with a new line.
+type lineReader struct {
+	next func() ([]byte, error)
+
+	cur []byte
+	err error
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.cur) == 0 {
+			if r.err != nil {
+				break
+			}
+			line, err := r.next()
+			if err != nil {
+				r.err = err
+				break
+			}
+			r.cur = append(append(r.cur[:0], line...), '\n')
+		}
+		c := copy(p[n:], r.cur)
+		r.cur = r.cur[c:]
+		n += c
+	}
+
+	if n == 0 && r.err != nil {
+		return 0, r.err
+	}
+	return n, nil
+}
@@ -0,0 +1,149 @@
+package sorter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sortAll(t *testing.T, s *Sorter, in string) string {
+	defer s.Close()
+
+	// write in uneven chunks so lines are split between writes
+	for len(in) > 0 {
+		n := rand.Intn(20) + 1
+		if n > len(in) {
+			n = len(in)
+		}
+		if _, err := s.Write([]byte(in[:n])); err != nil {
+			t.Fatal(err)
+		}
+		in = in[n:]
+	}
+
+	r, err := s.Sort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestSortByKey(t *testing.T) {
+	in := "b\t2\na\t3\nb\t1\na b\t1\na\t1\n\nb\nc"
+	expected := "\t\na\t3\na\t1\na b\t1\nb\t2\nb\t1\nb\t\nc\t\n"
+
+	out := sortAll(t, New(nil), in)
+	if out != expected {
+		t.Errorf("%q\n!=\n%q", out, expected)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf("key%d\t%d", rand.Intn(100), i))
+	}
+	in := strings.Join(lines, "\n") + "\n"
+
+	expected := sortAll(t, New(nil), in)
+
+	dir, err := ioutil.TempDir("", "sorter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := New(&Config{MemoryLimit: 4096, TempDir: dir})
+	out := sortAll(t, s, in)
+
+	if out != expected {
+		t.Errorf("Spilled sort doesn't match the in memory sort")
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return strings.Split(lines[i], "\t")[0] < strings.Split(lines[j], "\t")[0]
+	})
+	if out != strings.Join(lines, "\n")+"\n" {
+		t.Errorf("Spilled sort isn't stable")
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Temporary files not removed: %d", len(files))
+	}
+}
+
+func TestWriteAfterSort(t *testing.T) {
+	s := New(nil)
+	s.Sort()
+	if _, err := s.Write([]byte("a\n")); err != ErrSorted {
+		t.Errorf("Expected sorted error, got %v", err)
+	}
+}
+
+func TestKeyComparatorOptions(t *testing.T) {
+	tests := []struct {
+		options  string
+		a, b     string
+		expected int
+	}{
+		{"", "a", "b", -1},
+		{"-r", "a", "b", 1},
+		{"-n", "10", "9", 1},
+		{"-k2,2n", "x.10", "a.9", 1},
+		{"-k2,2nr", "x.10", "a.9", -1},
+		{"-k1,1 -k2,2nr", "a.1", "a.2", 1},
+		{"-k2", "a.b.c", "x.b.c", 0},
+		{"-k3,3", "a.b", "a.b.c", -1},
+		{"-nr -k2,2", "a.1", "a.2", 1},
+	}
+
+	for _, test := range tests {
+		compare, err := ParseKeyComparatorOptions(test.options, '.')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := compare([]byte(test.a), []byte(test.b)); c != test.expected {
+			t.Errorf("%s: compare(%s, %s) = %d != %d", test.options, test.a, test.b, c, test.expected)
+		}
+	}
+
+	for _, options := range []string{"-k0", "-k2,1", "-k1.2", "-k1x", "-f"} {
+		if _, err := ParseKeyComparatorOptions(options, '.'); err == nil {
+			t.Errorf("Expected error for %s", options)
+		}
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	in := ""
+	for i := 0; i < 100; i++ {
+		in += fmt.Sprintf("k%d\t%d\n", i%3, i)
+	}
+
+	ordered := sortAll(t, New(nil), in)
+	shuffled := sortAll(t, New(&Config{Shuffle: rand.New(rand.NewSource(1))}), in)
+
+	if shuffled == ordered {
+		t.Errorf("Values of equal keys weren't shuffled")
+	}
+
+	keys := func(out string) string {
+		ks := []string{}
+		for _, line := range strings.Split(out, "\n") {
+			ks = append(ks, strings.Split(line, "\t")[0])
+		}
+		return strings.Join(ks, ",")
+	}
+	if keys(shuffled) != keys(ordered) {
+		t.Errorf("Shuffled lines aren't sorted by key")
+	}
+}
@@ -0,0 +1,169 @@
+package job
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+var (
+	ErrUnknownStage = fmt.Errorf("Unknown stage")
+	ErrUnknownJob   = fmt.Errorf("Unknown job")
+)
+
+// Job holds mapper, combiner and reducer functions of a mapreduce job, wrapped so they read and write raw streams.
+type Job struct {
+	codec string
+
+	mapper   func(io.Writer, io.Reader)
+	combiner func(io.Writer, io.Reader)
+	reducer  func(io.Writer, io.Reader)
+
+	config interface{}
+}
+
+// NewRawJob creates a job from raw mapper and reducer functions.
+func NewRawJob(mapper func(io.Writer, io.Reader), reducer func(io.Writer, io.Reader)) *Job {
+	return &Job{
+		codec:   "raw",
+		mapper:  mapper,
+		reducer: reducer,
+	}
+}
+
+// NewByteJob creates a job from mapper and reducer functions using the byte reader/writer.
+func NewByteJob(mapper func(*ByteKVWriter, io.Reader), reducer func(io.Writer, *ByteKVReader)) *Job {
+	return &Job{
+		codec: "byte",
+		mapper: func(w io.Writer, r io.Reader) {
+			kvw := NewByteKVWriter(w)
+			mapper(kvw, r)
+			kvw.Flush()
+		},
+		reducer: func(w io.Writer, r io.Reader) {
+			reducer(w, NewByteKVReader(r))
+		},
+	}
+}
+
+// NewJsonJob creates a job from mapper and reducer functions using the json reader/writer.
+func NewJsonJob(mapper func(*JsonKVWriter, io.Reader), reducer func(io.Writer, *JsonKVReader)) *Job {
+	return &Job{
+		codec: "json",
+		mapper: func(w io.Writer, r io.Reader) {
+			kvw := NewJsonKVWriter(w)
+			mapper(kvw, r)
+			kvw.Flush()
+		},
+		reducer: func(w io.Writer, r io.Reader) {
+			reducer(w, NewJsonKVReader(r))
+		},
+	}
+}
+
+// WithRawCombiner sets a raw combiner which reads sorted mapper output and writes it in the mapper output format.
+func (j *Job) WithRawCombiner(combiner func(io.Writer, io.Reader)) *Job {
+	j.combiner = combiner
+	return j
+}
+
+// WithByteCombiner sets a combiner using the byte reader/writer.
+func (j *Job) WithByteCombiner(combiner func(*ByteKVWriter, *ByteKVReader)) *Job {
+	j.combiner = func(w io.Writer, r io.Reader) {
+		kvw := NewByteKVWriter(w)
+		combiner(kvw, NewByteKVReader(r))
+		kvw.Flush()
+	}
+	return j
+}
+
+// WithJsonCombiner sets a combiner using the json reader/writer.
+func (j *Job) WithJsonCombiner(combiner func(*JsonKVWriter, *JsonKVReader)) *Job {
+	j.combiner = func(w io.Writer, r io.Reader) {
+		kvw := NewJsonKVWriter(w)
+		combiner(kvw, NewJsonKVReader(r))
+		kvw.Flush()
+	}
+	return j
+}
+
+// WithConfig sets an example of the value the job decodes its config into with Config. It's only used to describe the config schema.
+func (j *Job) WithConfig(config interface{}) *Job {
+	j.config = config
+	return j
+}
+
+// Codec returns the name of the reader/writer used by the job: raw, byte or json.
+func (j *Job) Codec() string {
+	return j.codec
+}
+
+// Map runs the mapper, reading the input from r and writing encoded key, value pairs to w.
+func (j *Job) Map(w io.Writer, r io.Reader) {
+	j.mapper(w, r)
+}
+
+// HasCombiner reports whether the job has a combiner.
+func (j *Job) HasCombiner() bool {
+	return j.combiner != nil
+}
+
+// Combine runs the combiner, reading sorted key, value pairs from r and writing combined pairs to w.
+// Jobs without a combiner copy the input to the output.
+func (j *Job) Combine(w io.Writer, r io.Reader) {
+	if j.combiner == nil {
+		io.Copy(w, r)
+		return
+	}
+	j.combiner(w, r)
+}
+
+// Reduce runs the reducer, reading sorted key, value pairs from r and writing the results to w.
+func (j *Job) Reduce(w io.Writer, r io.Reader) {
+	j.reducer(w, r)
+}
+
+// Run runs the selected stage of the job.
+func (j *Job) Run(stage string, w io.Writer, r io.Reader) error {
+	switch stage {
+	case "mapper":
+		j.Map(w, r)
+	case "combiner":
+		j.Combine(w, r)
+	case "reducer":
+		j.Reduce(w, r)
+	default:
+		return ErrUnknownStage
+	}
+	return nil
+}
+
+var registry = map[string]*Job{}
+
+// Register makes a job available under the provided name so multiple jobs can be built into a single binary.
+// Registered jobs are started with Init and selected with the --job flag. Register panics if the name is used twice.
+func Register(name string, j *Job) {
+	if _, ok := registry[name]; ok {
+		panic("job: Register called twice for job " + name)
+	}
+	registry[name] = j
+}
+
+// Lookup returns a registered job.
+func Lookup(name string) (*Job, error) {
+	j, ok := registry[name]
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+	return j, nil
+}
+
+// Registered returns sorted names of all the registered jobs.
+func Registered() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
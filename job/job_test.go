@@ -0,0 +1,124 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	defer func() { registry = map[string]*Job{} }()
+
+	upper := NewRawJob(func(w io.Writer, r io.Reader) {
+		b, _ := ioutil.ReadAll(r)
+		w.Write(bytes.ToUpper(b))
+	}, func(w io.Writer, r io.Reader) {
+		io.Copy(w, r)
+	})
+	count := NewByteJob(func(w *ByteKVWriter, r io.Reader) {}, func(w io.Writer, r *ByteKVReader) {})
+
+	Register("upper", upper)
+	Register("count", count)
+
+	if names := strings.Join(Registered(), ","); names != "count,upper" {
+		t.Errorf("Invalid registered jobs: %s", names)
+	}
+
+	j, err := Lookup("upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Codec() != "raw" {
+		t.Errorf("Invalid codec: %s", j.Codec())
+	}
+
+	out := &bytes.Buffer{}
+	if err := j.Run("mapper", out, strings.NewReader("word\n")); err != nil {
+		t.Error(err)
+	}
+	if out.String() != "WORD\n" {
+		t.Errorf("Invalid mapper output: %s", out.String())
+	}
+
+	if err := j.Run("sorter", out, strings.NewReader("")); err != ErrUnknownStage {
+		t.Errorf("Expected unknown stage error, got %v", err)
+	}
+
+	if _, err := Lookup("missing"); err != ErrUnknownJob {
+		t.Errorf("Expected unknown job error, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Registering a job twice should panic")
+		}
+	}()
+	Register("count", count)
+}
+
+func TestDescribe(t *testing.T) {
+	defer func() { registry = map[string]*Job{} }()
+
+	type filter struct {
+		Field  string
+		Values []string
+	}
+	type config struct {
+		Date    string `json:"date"`
+		Limit   int    `json:"limit,omitempty"`
+		Skip    bool   `json:"-"`
+		Filters []*filter
+		Weights map[string]float64
+	}
+
+	combine := func(w *ByteKVWriter, r *ByteKVReader) {}
+	Register("count", NewByteJob(func(w *ByteKVWriter, r io.Reader) {}, func(w io.Writer, r *ByteKVReader) {}).WithByteCombiner(combine).WithConfig(&config{}))
+
+	d := Describe()
+	jd, err := d.Job("count")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !jd.Combiner || jd.Stages["combiner"] != "byte" {
+		t.Errorf("Invalid combiner description: %+v", jd)
+	}
+
+	b, _ := json.Marshal(jd.Config)
+	expected := `{"type":"object","fields":{"Filters":{"type":"array","elem":{"type":"object","fields":{"Field":{"type":"string"},"Values":{"type":"array","elem":{"type":"string"}}}}},"Weights":{"type":"map","elem":{"type":"number"}},"date":{"type":"string"},"limit":{"type":"integer"}}}`
+	if string(b) != expected {
+		t.Errorf("Invalid config schema:\n%s\n!=\n%s", b, expected)
+	}
+
+	valid := []string{
+		`{"date":"2016-01-01","limit":10}`,
+		`{"Date":"2016-01-01","filters":[{"Field":"a","Values":["b"]}],"Weights":{"a":0.5}}`,
+		`{"Filters":null}`,
+	}
+	invalid := map[string]string{
+		`{"date":1}`:                   "Invalid config value config.date: expected string",
+		`{"limit":1.5}`:                "Invalid config value config.limit: expected integer",
+		`{"unknown":1}`:                "Unknown config field config.unknown",
+		`{"Filters":[{"Values":[1]}]}`: "Invalid config value config.Filters[0].Values[0]: expected string",
+		`{"Weights":{"a":"b"}}`:        "Invalid config value config.Weights.a: expected number",
+		`[]`:                           "Invalid config value config: expected object",
+	}
+
+	for _, v := range valid {
+		var dv interface{}
+		json.Unmarshal([]byte(v), &dv)
+		if err := jd.Config.Validate(dv); err != nil {
+			t.Errorf("%s: %s", v, err)
+		}
+	}
+	for v, msg := range invalid {
+		var dv interface{}
+		json.Unmarshal([]byte(v), &dv)
+		if err := jd.Config.Validate(dv); err == nil || err.Error() != msg {
+			t.Errorf("%s: expected error %s, got %v", v, msg, err)
+		}
+	}
+}
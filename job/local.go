@@ -0,0 +1,141 @@
+package job
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Zemanta/mrgob/job/internal/environ"
+	"github.com/Zemanta/mrgob/job/internal/sorter"
+)
+
+var (
+	ErrMissingLocalInput  = fmt.Errorf("Missing local input")
+	ErrMissingLocalOutput = fmt.Errorf("Missing local output")
+)
+
+// LocalConfig configures a local run of the job.
+type LocalConfig struct {
+	// Input files or glob patterns. Each file is processed by a separate mapper call.
+	Input []string
+	// Output directory. Reducer output is written to the part-00000 file.
+	Output string
+
+	// Json encoded job config made available through Config. The current environment is used when empty.
+	Config string
+
+	// Maximum number of bytes the sort buffers in memory before spilling to disk. Defaults to 256MB.
+	SortMemory int
+	// Directory for the temporary sort files. Defaults to os.TempDir().
+	TempDir string
+}
+
+func localInputFiles(patterns []string) ([]string, error) {
+	files := []string{}
+	for _, p := range patterns {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No input files match %s", p)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, ErrMissingLocalInput
+	}
+	return files, nil
+}
+
+// RunLocal runs map, sort, combine and reduce stages of the job in process, reading local input files and writing results to the local output directory.
+// The mapper output is sorted with an external merge sort, so inputs don't have to fit in memory.
+// The environment is set up the same way as in hadoop streaming.
+func (j *Job) RunLocal(cfg *LocalConfig) error {
+	files, err := localInputFiles(cfg.Input)
+	if err != nil {
+		return err
+	}
+	if cfg.Output == "" {
+		return ErrMissingLocalOutput
+	}
+	if err := os.MkdirAll(cfg.Output, 0755); err != nil {
+		return err
+	}
+
+	if cfg.Config != "" {
+		defer environ.Set(ConfigEnv, cfg.Config)()
+	}
+
+	sortConfig := &sorter.Config{
+		MemoryLimit: cfg.SortMemory,
+		TempDir:     cfg.TempDir,
+	}
+
+	shuffle := sorter.New(sortConfig)
+	defer shuffle.Close()
+
+	for _, fn := range files {
+		if err := j.runLocalMapper(fn, shuffle, sortConfig); err != nil {
+			return err
+		}
+	}
+
+	sorted, err := shuffle.Sort()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(filepath.Join(cfg.Output, "part-00000"))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	j.Reduce(w, sorted)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(cfg.Output, "_SUCCESS"), nil, 0644)
+}
+
+func (j *Job) runLocalMapper(fn string, shuffle io.Writer, sortConfig *sorter.Config) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return err
+	}
+	defer environ.Set(InputFileEnv, "file:"+abs)()
+
+	if !j.HasCombiner() {
+		j.Map(shuffle, f)
+		return nil
+	}
+
+	s := sorter.New(sortConfig)
+	defer s.Close()
+
+	j.Map(s, f)
+
+	sorted, err := s.Sort()
+	if err != nil {
+		return err
+	}
+	j.Combine(shuffle, sorted)
+	return nil
+}
@@ -0,0 +1,103 @@
+package job
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRunLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "in1.txt"), []byte("b a c\na\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "in2.txt"), []byte("c b\nb"), 0644)
+
+	files := []string{}
+	combined := 0
+
+	mapper := func(w *ByteKVWriter, r io.Reader) {
+		files = append(files, filepath.Base(os.Getenv(InputFileEnv)))
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.Write([]byte(word), []byte("1"))
+			}
+		}
+	}
+	sum := func(vr *ByteValueReader) int {
+		c := 0
+		for vr.Scan() {
+			n, _ := strconv.Atoi(string(vr.Value()))
+			c += n
+		}
+		return c
+	}
+	combiner := func(w *ByteKVWriter, r *ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			w.Write(key, []byte(strconv.Itoa(sum(vr))))
+			combined++
+		}
+	}
+	reducer := func(w io.Writer, r *ByteKVReader) {
+		cfg := map[string]string{}
+		if err := Config(&cfg); err != nil {
+			t.Error(err)
+		}
+		for r.Scan() {
+			key, vr := r.Key()
+			fmt.Fprintf(w, "%s%s\t%d\n", cfg["prefix"], key, sum(vr))
+		}
+	}
+
+	j := NewByteJob(mapper, reducer).WithByteCombiner(combiner)
+
+	out := filepath.Join(dir, "out")
+	err = j.RunLocal(&LocalConfig{
+		Input:      []string{filepath.Join(dir, "*.txt")},
+		Output:     out,
+		Config:     `{"prefix":"w_"}`,
+		SortMemory: 16,
+		TempDir:    dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ioutil.ReadFile(filepath.Join(out, "part-00000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "w_a\t2\nw_b\t3\nw_c\t2\n"
+	if string(res) != expected {
+		t.Errorf("\n%s\n!=\n%s", res, expected)
+	}
+
+	if strings.Join(files, ",") != "in1.txt,in2.txt" {
+		t.Errorf("Invalid input files: %v", files)
+	}
+	if combined != 5 {
+		t.Errorf("Invalid number of combined keys: %d", combined)
+	}
+	if _, err := os.Stat(filepath.Join(out, "_SUCCESS")); err != nil {
+		t.Error(err)
+	}
+	if os.Getenv(ConfigEnv) != "" {
+		t.Error("Config env not restored")
+	}
+
+	if err := j.RunLocal(&LocalConfig{Input: []string{filepath.Join(dir, "*.csv")}, Output: out}); err == nil {
+		t.Error("Expected missing input error")
+	}
+}
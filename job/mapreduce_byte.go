@@ -105,8 +105,13 @@ func (r *ByteKVReader) Scan() bool {
 
 // Key returns decoded key and reader for all values belonging to this key.
 // The underlying array may point to data that will be overwritten by a subsequent call to Scan. It does no allocation.
+// Invalid escape sequences are counted as decode errors.
 func (r *ByteKVReader) Key() ([]byte, *ByteValueReader) {
-	return decodeBytes(r.key), r.vr
+	key, err := decodeBytes(r.key)
+	if err != nil {
+		countDecodeError()
+	}
+	return key, r.vr
 }
 
 // Err returns the first non-EOF error that was encountered by the reader.
@@ -124,9 +129,10 @@ type ByteValueReader struct {
 	skip int
 	done bool
 
-	err   error
-	key   []byte
-	value []byte
+	err    error
+	key    []byte
+	hasKey bool
+	value  []byte
 }
 
 // Scan advances the reader to the next value, which will then be available through the Value method.
@@ -161,13 +167,15 @@ func (r *ByteValueReader) Scan() bool {
 
 	key := line[0:split]
 
+	// keys can be empty, so the first key is tracked separately
 	ok := true
-	if len(r.key) != 0 && !bytes.Equal(key, r.key) {
+	if r.hasKey && !bytes.Equal(key, r.key) {
 		ok = false
 	}
 
-	if len(r.key) == 0 || !ok {
+	if !r.hasKey || !ok {
 		r.key = copyResize(r.key, key)
+		r.hasKey = true
 	}
 
 	if len(line) > split {
@@ -181,8 +189,13 @@ func (r *ByteValueReader) Scan() bool {
 
 // Value decodes the current value and returns it.
 // The underlying array may point to data that will be overwritten by a subsequent call to Scan. It does no allocation.
+// Invalid escape sequences are counted as decode errors.
 func (r *ByteValueReader) Value() []byte {
-	return decodeBytes(r.value)
+	value, err := decodeBytes(r.value)
+	if err != nil {
+		countDecodeError()
+	}
+	return value
 }
 
 // Err returns the first non-EOF error that was encountered by the reader.
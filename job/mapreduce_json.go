@@ -94,7 +94,11 @@ func (r *JsonKVReader) Scan() bool {
 
 // Key decodes the current key into the target interface and returns a reader for all values belonging to this key.
 func (r *JsonKVReader) Key(target interface{}) (*JsonValueReader, error) {
-	return r.vr, json.Unmarshal(r.key, target)
+	err := json.Unmarshal(r.key, target)
+	if err != nil {
+		countDecodeError()
+	}
+	return r.vr, err
 }
 
 // Err returns the first non-EOF error that was encountered by the reader.
@@ -112,9 +116,10 @@ type JsonValueReader struct {
 	skip int
 	done bool
 
-	err   error
-	key   []byte
-	value []byte
+	err    error
+	key    []byte
+	hasKey bool
+	value  []byte
 }
 
 // Scan advances the reader to the next value, which will then be available through the Value method.
@@ -149,13 +154,15 @@ func (r *JsonValueReader) Scan() bool {
 
 	key := line[0:split]
 
+	// keys can be empty, so the first key is tracked separately
 	ok := true
-	if len(r.key) != 0 && !bytes.Equal(key, r.key) {
+	if r.hasKey && !bytes.Equal(key, r.key) {
 		ok = false
 	}
 
-	if len(r.key) == 0 || !ok {
+	if !r.hasKey || !ok {
 		r.key = copyResize(r.key, key)
+		r.hasKey = true
 	}
 
 	if len(line) > split {
@@ -169,7 +176,11 @@ func (r *JsonValueReader) Scan() bool {
 
 // Value decodes the current value into the target interface.
 func (r *JsonValueReader) Value(target interface{}) error {
-	return json.Unmarshal(r.value, target)
+	err := json.Unmarshal(r.value, target)
+	if err != nil {
+		countDecodeError()
+	}
+	return err
 }
 
 // Err returns the first non-EOF error that was encountered by the reader.
@@ -45,10 +45,19 @@ func TestEncodeDecodeBytes(t *testing.T) {
 		t.Errorf("%s\n!=\n%s", out, exp)
 	}
 
-	dec := string(decodeBytes([]byte(out)))
-	if dec != in {
+	dec, err := decodeBytes([]byte(out))
+	if err != nil {
+		t.Error(err)
+	}
+	if string(dec) != in {
 		t.Errorf("%s\n!=\n%s", dec, in)
 	}
+
+	for _, invalid := range []string{"a\\x", "a\\"} {
+		if _, err := decodeBytes([]byte(invalid)); err != ErrInvalidLine {
+			t.Errorf("Invalid error of %q: %v", invalid, err)
+		}
+	}
 }
 
 func TestEncodeWriterTab(t *testing.T) {
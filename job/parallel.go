@@ -0,0 +1,226 @@
+package job
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+var defaultParallelBatchSize = 256
+
+// ParallelConfig configures parallel record processing in mappers.
+type ParallelConfig struct {
+	// Number of worker goroutines. Defaults to runtime.GOMAXPROCS(0).
+	Workers int
+	// Number of input lines handed to a worker at once. Defaults to 256.
+	BatchSize int
+	// Keep the output in the same order as the input. Relaxed ordering writes each batch as soon as it's processed.
+	Ordered bool
+}
+
+// Counters collects counter increments in memory so they can be reported with a single Count call per counter.
+type Counters map[string]int
+
+// Count increases the counter by c.
+func (cs Counters) Count(name string, c int) {
+	cs[name] += c
+}
+
+// Flush reports all collected counters with Count and resets them.
+func (cs Counters) Flush() {
+	for name, c := range cs {
+		Count(name, c)
+		delete(cs, name)
+	}
+}
+
+type parallelBatch struct {
+	seq   int
+	line  int
+	lines [][]byte
+
+	out *bytes.Buffer
+	err error
+}
+
+// processBatch processes all the lines in the batch, writing the results to the batch output buffer.
+type processBatch func(b *parallelBatch, cs Counters) error
+
+func (c *ParallelConfig) workers() int {
+	if c == nil || c.Workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return c.Workers
+}
+
+func (c *ParallelConfig) batchSize() int {
+	if c == nil || c.BatchSize <= 0 {
+		return defaultParallelBatchSize
+	}
+	return c.BatchSize
+}
+
+func readBatches(r io.Reader, size int, batches chan<- *parallelBatch, quit <-chan struct{}) error {
+	reader := bufio.NewReader(r)
+
+	seq, lineNo := 0, 1
+	b := &parallelBatch{seq: seq, line: lineNo}
+
+	send := func() bool {
+		select {
+		case batches <- b:
+		case <-quit:
+			return false
+		}
+		seq++
+		b = &parallelBatch{seq: seq, line: lineNo}
+		return true
+	}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if n := len(line); n > 0 && line[n-1] == '\n' {
+			line = line[:n-1]
+		} else if err == io.EOF && n == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		b.lines = append(b.lines, line)
+		lineNo++
+
+		if len(b.lines) >= size && !send() {
+			return nil
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if len(b.lines) > 0 {
+		send()
+	}
+	return nil
+}
+
+func runParallel(w *bufio.Writer, r io.Reader, cfg *ParallelConfig, process processBatch) error {
+	nworkers := cfg.workers()
+	ordered := cfg != nil && cfg.Ordered
+
+	batches := make(chan *parallelBatch, nworkers)
+	results := make(chan *parallelBatch, nworkers)
+	quit := make(chan struct{})
+
+	var readErr error
+	go func() {
+		readErr = readBatches(r, cfg.batchSize(), batches, quit)
+		close(batches)
+	}()
+
+	counters := make([]Counters, nworkers)
+	wg := &sync.WaitGroup{}
+	wg.Add(nworkers)
+	for i := 0; i < nworkers; i++ {
+		counters[i] = Counters{}
+		go func(cs Counters) {
+			defer wg.Done()
+			for b := range batches {
+				b.out = &bytes.Buffer{}
+				b.err = process(b, cs)
+				results <- b
+			}
+		}(counters[i])
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var err error
+	fail := func(e error) {
+		if err == nil {
+			err = e
+			close(quit)
+		}
+	}
+	write := func(b *parallelBatch) {
+		if err != nil {
+			return
+		}
+		if b.err != nil {
+			fail(b.err)
+			return
+		}
+		if _, werr := w.Write(b.out.Bytes()); werr != nil {
+			fail(werr)
+		}
+	}
+
+	pending := map[int]*parallelBatch{}
+	next := 0
+	for b := range results {
+		if !ordered {
+			write(b)
+			continue
+		}
+		pending[b.seq] = b
+		for pb, ok := pending[next]; ok; pb, ok = pending[next] {
+			delete(pending, next)
+			write(pb)
+			next++
+		}
+	}
+
+	if err == nil {
+		err = readErr
+	}
+
+	total := Counters{}
+	for _, cs := range counters {
+		for name, c := range cs {
+			total.Count(name, c)
+		}
+	}
+	total.Flush()
+
+	return err
+}
+
+// ParallelByteMap reads lines from the reader and calls fn for each of them from a pool of goroutines.
+// Records written by fn are passed on to the writer in batches, counters are aggregated and reported once all the lines are processed.
+// The first error returned by fn stops the processing and is returned together with the line number.
+func ParallelByteMap(w *ByteKVWriter, r io.Reader, cfg *ParallelConfig, fn func(w *ByteKVWriter, cs Counters, line []byte) error) error {
+	return runParallel(w.w, r, cfg, func(b *parallelBatch, cs Counters) error {
+		bw := NewByteKVWriter(b.out)
+		defer bw.Flush()
+
+		for i, line := range b.lines {
+			if err := fn(bw, cs, line); err != nil {
+				return fmt.Errorf("line %d: %s", b.line+i, err)
+			}
+		}
+		return nil
+	})
+}
+
+// ParallelJsonMap reads lines from the reader and calls fn for each of them from a pool of goroutines.
+// Records written by fn are passed on to the writer in batches, counters are aggregated and reported once all the lines are processed.
+// The first error returned by fn stops the processing and is returned together with the line number.
+func ParallelJsonMap(w *JsonKVWriter, r io.Reader, cfg *ParallelConfig, fn func(w *JsonKVWriter, cs Counters, line []byte) error) error {
+	return runParallel(w.w, r, cfg, func(b *parallelBatch, cs Counters) error {
+		jw := NewJsonKVWriter(b.out)
+		defer jw.Flush()
+
+		for i, line := range b.lines {
+			if err := fn(jw, cs, line); err != nil {
+				return fmt.Errorf("line %d: %s", b.line+i, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,192 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func parallelTestInput(n int) string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line%d", i)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func captureCounters(t testing.TB, fn func()) string {
+	f, err := ioutil.TempFile("", "counters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	pipe := CounterPipe
+	CounterPipe = f
+	defer func() { CounterPipe = pipe }()
+
+	fn()
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestParallelByteMapOrdered(t *testing.T) {
+	in := parallelTestInput(1000)
+
+	expected := &bytes.Buffer{}
+	w := NewByteKVWriter(expected)
+	for _, line := range strings.Split(in, "\n") {
+		w.Write([]byte(line), []byte("1"))
+	}
+	w.Flush()
+
+	out := &bytes.Buffer{}
+	w = NewByteKVWriter(out)
+	cfg := &ParallelConfig{Workers: 4, BatchSize: 7, Ordered: true}
+	err := ParallelByteMap(w, strings.NewReader(in), cfg, func(w *ByteKVWriter, cs Counters, line []byte) error {
+		return w.Write(line, []byte("1"))
+	})
+	w.Flush()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("Ordered output doesn't match the input order")
+	}
+}
+
+func TestParallelJsonMapUnordered(t *testing.T) {
+	in := parallelTestInput(1000) + "\n"
+
+	out := &bytes.Buffer{}
+	w := NewJsonKVWriter(out)
+	cfg := &ParallelConfig{Workers: 4, BatchSize: 3}
+	err := ParallelJsonMap(w, strings.NewReader(in), cfg, func(w *JsonKVWriter, cs Counters, line []byte) error {
+		return w.Write(string(line), len(line))
+	})
+	w.Flush()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1000 {
+		t.Fatalf("Invalid number of output lines: %d != 1000", len(lines))
+	}
+
+	keys := []string{}
+	for _, line := range lines {
+		var k string
+		if err := json.Unmarshal([]byte(strings.Split(line, "\t")[0]), &k); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	expected := strings.Split(in[:len(in)-1], "\n")
+	sort.Strings(expected)
+
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unordered output doesn't contain all the input lines")
+	}
+}
+
+func TestParallelCounters(t *testing.T) {
+	in := parallelTestInput(100)
+
+	var err error
+	out := captureCounters(t, func() {
+		w := NewByteKVWriter(ioutil.Discard)
+		err = ParallelByteMap(w, strings.NewReader(in), &ParallelConfig{Workers: 3, BatchSize: 10}, func(w *ByteKVWriter, cs Counters, line []byte) error {
+			cs.Count("lines", 1)
+			cs.Count("double", 2)
+			return nil
+		})
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	sort.Strings(lines)
+	expected := "reporter:counter:GOMR,double,200\nreporter:counter:GOMR,lines,100"
+	if strings.Join(lines, "\n") != expected {
+		t.Errorf("Invalid counters:\n%s\n!=\n%s", out, expected)
+	}
+}
+
+func TestParallelError(t *testing.T) {
+	in := parallelTestInput(1000)
+
+	w := NewByteKVWriter(ioutil.Discard)
+	err := ParallelByteMap(w, strings.NewReader(in), &ParallelConfig{Workers: 4, BatchSize: 10, Ordered: true}, func(w *ByteKVWriter, cs Counters, line []byte) error {
+		if string(line) == "line500" {
+			return fmt.Errorf("bad line")
+		}
+		return nil
+	})
+
+	if err == nil || err.Error() != "line 501: bad line" {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func benchmarkParallelMapper(b *testing.B, procs int) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+
+	type record struct {
+		Id    int
+		Name  string
+		Score float64
+		Tags  []string
+	}
+
+	buf := &bytes.Buffer{}
+	for i := 0; i < b.N; i++ {
+		json.NewEncoder(buf).Encode(&record{Id: i, Name: "some record name", Score: 1.5, Tags: []string{"a", "b", "c"}})
+	}
+
+	w := NewByteKVWriter(ioutil.Discard)
+
+	b.ResetTimer()
+
+	err := ParallelByteMap(w, buf, nil, func(w *ByteKVWriter, cs Counters, line []byte) error {
+		rec := &record{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return err
+		}
+		cs.Count("records", 1)
+		return w.Write([]byte(rec.Name), []byte(rec.Tags[0]))
+	})
+	w.Flush()
+
+	if err != nil {
+		b.Error(err)
+	}
+}
+
+func BenchmarkParallelMapper(b *testing.B) {
+	defer func(p *os.File) { CounterPipe = p }(CounterPipe)
+	CounterPipe = nil
+
+	for _, procs := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("procs=%d", procs), func(b *testing.B) {
+			benchmarkParallelMapper(b, procs)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package job
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// StatsCounterGroup is the counter group used for throughput and timing counters of instrumented jobs.
+var StatsCounterGroup = "GOMR_STATS"
+
+// ReportStats enables throughput and timing counters for jobs started with Init*Job.
+// They can also be enabled without code changes by setting the StatsEnv environment variable to true.
+var ReportStats = false
+
+// StatsEnv is the environment variable enabling throughput and timing counters.
+const StatsEnv = "mrgob_stats"
+
+// Names of the counters reported for each stage, prefixed with the stage name, e.g. mapper_records_in.
+const (
+	StatRecordsIn    = "records_in"
+	StatRecordsOut   = "records_out"
+	StatBytesIn      = "bytes_in"
+	StatBytesOut     = "bytes_out"
+	StatUserMs       = "user_ms"
+	StatIOMs         = "io_ms"
+	StatDecodeErrors = "decode_errors"
+)
+
+var decodeErrors int64
+
+func countDecodeError() {
+	atomic.AddInt64(&decodeErrors, 1)
+}
+
+func statsEnabled() bool {
+	return ReportStats || os.Getenv(StatsEnv) == "true"
+}
+
+// taskStats measures records, bytes and time spent reading the input and writing the output of a task.
+type taskStats struct {
+	start time.Time
+
+	in  *statsReader
+	out *statsWriter
+}
+
+type statsReader struct {
+	r io.Reader
+
+	records int
+	bytes   int
+	last    byte
+	io      time.Duration
+}
+
+func (sr *statsReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := sr.r.Read(p)
+	sr.io += time.Since(start)
+
+	if n > 0 {
+		sr.bytes += n
+		sr.records += bytes.Count(p[:n], nl)
+		sr.last = p[n-1]
+	}
+	return n, err
+}
+
+type statsWriter struct {
+	w io.Writer
+
+	records int
+	bytes   int
+	io      time.Duration
+}
+
+func (sw *statsWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := sw.w.Write(p)
+	sw.io += time.Since(start)
+
+	sw.bytes += n
+	sw.records += bytes.Count(p[:n], nl)
+	return n, err
+}
+
+func newTaskStats(w io.Writer, r io.Reader) *taskStats {
+	atomic.StoreInt64(&decodeErrors, 0)
+	return &taskStats{
+		start: time.Now(),
+		in:    &statsReader{r: r},
+		out:   &statsWriter{w: w},
+	}
+}
+
+func (s *taskStats) counters(stage string) Counters {
+	total := time.Since(s.start)
+	ioTime := s.in.io + s.out.io
+
+	recordsIn := s.in.records
+	// unterminated last line
+	if s.in.bytes > 0 && s.in.last != '\n' {
+		recordsIn++
+	}
+
+	return Counters{
+		stage + "_" + StatRecordsIn:    recordsIn,
+		stage + "_" + StatRecordsOut:   s.out.records,
+		stage + "_" + StatBytesIn:      s.in.bytes,
+		stage + "_" + StatBytesOut:     s.out.bytes,
+		stage + "_" + StatUserMs:       int((total - ioTime) / time.Millisecond),
+		stage + "_" + StatIOMs:         int(ioTime / time.Millisecond),
+		stage + "_" + StatDecodeErrors: int(atomic.LoadInt64(&decodeErrors)),
+	}
+}
+
+func (s *taskStats) report(stage string) {
+	for name, c := range s.counters(stage) {
+		countGroup(StatsCounterGroup, name, c)
+	}
+}
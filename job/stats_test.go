@@ -0,0 +1,80 @@
+package job
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTaskStats(t *testing.T) {
+	in := "\"a\"\t1\n\"a\"\t2\n\"b\"\tbad\n\"c\"\t3\n"
+	out := &bytes.Buffer{}
+
+	reducer := func(w io.Writer, r *JsonKVReader) {
+		for r.Scan() {
+			var key string
+			vr, _ := r.Key(&key)
+			sum := 0
+			for vr.Scan() {
+				var v int
+				if err := vr.Value(&v); err == nil {
+					sum += v
+				}
+			}
+			w.Write([]byte(key + "\n"))
+		}
+	}
+
+	stats := newTaskStats(out, strings.NewReader(in))
+	NewJsonJob(nil, reducer).Run("reducer", stats.out, stats.in)
+
+	cs := stats.counters("reducer")
+
+	expected := map[string]int{
+		"reducer_records_in":    4,
+		"reducer_records_out":   3,
+		"reducer_bytes_in":      len(in),
+		"reducer_bytes_out":     6,
+		"reducer_decode_errors": 1,
+	}
+	for name, c := range expected {
+		if cs[name] != c {
+			t.Errorf("Invalid %s counter: %d != %d", name, cs[name], c)
+		}
+	}
+	if _, ok := cs["reducer_user_ms"]; !ok {
+		t.Error("Missing user time counter")
+	}
+
+	counters := captureCounters(t, func() { stats.report("reducer") })
+	if !strings.Contains(counters, "reporter:counter:GOMR_STATS,reducer_records_in,4\n") {
+		t.Errorf("Stats not reported in the stats group:\n%s", counters)
+	}
+}
+
+func TestByteTaskStats(t *testing.T) {
+	in := "a\t1\na\t\\x\nb\\\t2\n"
+	out := &bytes.Buffer{}
+
+	reducer := func(w io.Writer, r *ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			for vr.Scan() {
+				vr.Value()
+			}
+			w.Write(append(key, '\n'))
+		}
+	}
+
+	stats := newTaskStats(out, strings.NewReader(in))
+	NewByteJob(nil, reducer).Run("reducer", stats.out, stats.in)
+
+	cs := stats.counters("reducer")
+	if cs["reducer_records_in"] != 3 || cs["reducer_records_out"] != 2 {
+		t.Errorf("Invalid record counters: %v", cs)
+	}
+	if cs["reducer_decode_errors"] != 2 {
+		t.Errorf("Invalid decode errors counter: %d != 2", cs["reducer_decode_errors"])
+	}
+}
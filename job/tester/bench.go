@@ -0,0 +1,173 @@
+package tester
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/mrgob/job"
+	"github.com/Zemanta/mrgob/job/internal/sorter"
+)
+
+// benchPhase is a phase of the job benchmarked separately over the output of the previous phase.
+type benchPhase struct {
+	name string
+	env  map[string]string
+	run  func(w io.Writer, r io.Reader) error
+
+	input   []byte
+	records int
+}
+
+func sortPhase(cfg *sorter.Config) func(io.Writer, io.Reader) error {
+	return func(w io.Writer, r io.Reader) error {
+		s := sorter.New(cfg)
+		defer s.Close()
+
+		if _, err := io.Copy(s, r); err != nil {
+			return err
+		}
+		sorted, err := s.Sort()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, sorted)
+		return err
+	}
+}
+
+func stagePhase(j *job.Job, stage string) func(io.Writer, io.Reader) error {
+	return func(w io.Writer, r io.Reader) error {
+		return j.Run(stage, w, r)
+	}
+}
+
+func countLines(b []byte) int {
+	n := bytes.Count(b, []byte{'\n'})
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (p *benchPhase) benchmark(b *testing.B, profileDir string) error {
+	setTaskEnv(p.env)
+
+	if profileDir != "" {
+		f, err := os.Create(filepath.Join(profileDir, p.name+".cpu.pprof"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.StartTimer()
+	start := time.Now()
+
+	for i := 0; i < b.N; i++ {
+		if err := p.run(ioutil.Discard, bytes.NewReader(p.input)); err != nil {
+			return err
+		}
+	}
+
+	elapsed := time.Since(start)
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+
+	if profileDir != "" {
+		if err := writeHeapProfile(filepath.Join(profileDir, p.name+".heap.pprof")); err != nil {
+			return err
+		}
+	}
+
+	records := float64(p.records * b.N)
+	b.ReportMetric(float64(elapsed.Nanoseconds())/float64(b.N), p.name+"-ns/op")
+	if records > 0 {
+		b.ReportMetric(records/elapsed.Seconds(), p.name+"-records/s")
+		b.ReportMetric(float64(after.Mallocs-before.Mallocs)/records, p.name+"-allocs/record")
+	}
+	return nil
+}
+
+// BenchmarkJob benchmarks the map, sort, combine (if the job has a combiner) and reduce phases of the job separately, each phase processing
+// the output of the previous one b.N times. The input is processed by a single mapper and reducer and only the sort, job config and environment
+// options are used. Time per op, records per second and allocations per input record of each phase are reported as benchmark metrics,
+// e.g. map-records/s.
+//
+// When profileDir is set, CPU and heap profiles of each phase are written to it, e.g. map.cpu.pprof and map.heap.pprof. Heap profiles are
+// cumulative, so compare them with the profile of the previous phase using pprof's -base flag.
+func BenchmarkJob(b *testing.B, j *job.Job, input []byte, opts *Options, profileDir string) {
+	b.StopTimer()
+
+	globalMu.Lock()
+	defer globalMu.Unlock()
+
+	// the task environment set by each phase is restored with the job environment
+	restoreEnv, err := opts.setJobEnv()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer restoreEnv()
+	defer captureGlobals(ioutil.Discard)()
+
+	cfg, err := opts.sorterConfig()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	mapTask := &mapTask{length: len(input)}
+	phases := []*benchPhase{
+		{name: "map", env: mapTask.env(0, 0), run: stagePhase(j, "mapper")},
+		{name: "sort", run: sortPhase(cfg)},
+	}
+	if j.HasCombiner() {
+		phases = append(phases, &benchPhase{name: "combine", env: mapTask.env(0, 0), run: stagePhase(j, "combiner")})
+	}
+	phases = append(phases, &benchPhase{name: "reduce", env: taskEnv(false, 0, 0), run: stagePhase(j, "reducer")})
+
+	// input of each phase is the output of the previous one
+	for _, p := range phases {
+		p.input = input
+		p.records = countLines(input)
+
+		setTaskEnv(p.env)
+		out := &bytes.Buffer{}
+		if err := p.run(out, bytes.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+		input = out.Bytes()
+	}
+
+	b.ResetTimer()
+	for _, p := range phases {
+		if err := p.benchmark(b, profileDir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
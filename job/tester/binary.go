@@ -0,0 +1,117 @@
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+var ErrTaskFailed = fmt.Errorf("Task failed")
+
+// Task holds the stderr and the exit status of a job binary task.
+type Task struct {
+	// Stage of the task, mapper, combiner or reducer.
+	Stage string
+	// Index of the task within its stage.
+	Index int
+
+	ExitCode int
+	Stderr   []byte
+}
+
+// BinaryResult holds the results of a job binary run.
+type BinaryResult struct {
+	*Result
+
+	// Tasks in the order they were run.
+	Tasks []*Task
+}
+
+// binaryRunner runs tasks as subprocesses of the job binary.
+type binaryRunner struct {
+	path    string
+	opts    *Options
+	env     []string
+	capture *captureWriter
+	tasks   []*Task
+	indexes map[string]int
+}
+
+func (br *binaryRunner) hasCombiner() bool {
+	return br.opts != nil && br.opts.Combiner
+}
+
+func (br *binaryRunner) runTask(stage string, env map[string]string, w io.Writer, r io.Reader) error {
+	args := []string{"-stage=" + stage}
+	if br.opts != nil && br.opts.JobName != "" {
+		args = append(args, "-job="+br.opts.JobName)
+	}
+
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.Command(br.path, args...)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	cmd.Stderr = io.MultiWriter(stderr, br.capture)
+	cmd.Env = append([]string{}, br.env...)
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	task := &Task{Stage: stage, Index: br.indexes[stage]}
+	br.indexes[stage]++
+
+	err := cmd.Run()
+	br.capture.flush()
+
+	if cmd.ProcessState == nil {
+		return err
+	}
+
+	task.ExitCode = cmd.ProcessState.ExitCode()
+	task.Stderr = stderr.Bytes()
+	br.tasks = append(br.tasks, task)
+
+	if _, ok := err.(*exec.ExitError); ok {
+		return ErrTaskFailed
+	}
+	return err
+}
+
+// RunBinary runs a compiled job binary the same way hadoop streaming does. Each task is a separate process started with -stage=mapper,
+// -stage=combiner or -stage=reducer, reading its input from stdin and writing the output to stdout. Mapper output is partitioned and sorted
+// in between the same way as in Run.
+//
+// Tasks inherit the environment of the test process, extended with the job config and environment variables of the options and the mapper input file.
+// Stderr of the tasks is parsed for counters and log lines. If a task exits with a non-zero status, ErrTaskFailed is returned
+// together with the result holding the tasks run so far.
+func RunBinary(path string, input []io.Reader, opts *Options) (*BinaryResult, error) {
+	jobEnv, err := opts.jobEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	env := os.Environ()
+	for k, v := range jobEnv {
+		env = append(env, k+"="+v)
+	}
+
+	capture := newCaptureWriter()
+	br := &binaryRunner{
+		path:    path,
+		opts:    opts,
+		env:     env,
+		capture: capture,
+		indexes: map[string]int{},
+	}
+
+	res := &BinaryResult{Result: &Result{}}
+	err = run(br, input, opts, res.Result, capture)
+
+	res.Counters = capture.counters
+	res.Logs = capture.logs
+	res.Tasks = br.tasks
+	return res, err
+}
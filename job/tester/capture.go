@@ -0,0 +1,182 @@
+package tester
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Zemanta/mrgob/job"
+	"github.com/Zemanta/mrgob/job/internal/hooks"
+)
+
+// Counter holds the values of a counter reported by mappers (including combiners), reducers and their total.
+type Counter struct {
+	Map    int
+	Reduce int
+	Total  int
+}
+
+// CountersGroup maps counter names to their values.
+type CountersGroup map[string]Counter
+
+// Counters holds counters of a simulated run grouped the same way as runner.HadoopJobCounters.
+type Counters map[string]CountersGroup
+
+// AppCounters returns counters reported with job.Count.
+func (c Counters) AppCounters() CountersGroup {
+	return c[job.AppCounterGroup]
+}
+
+func (c Counters) add(stage, group, name string, value int) {
+	g, ok := c[group]
+	if !ok {
+		g = CountersGroup{}
+		c[group] = g
+	}
+
+	counter := g[name]
+	if stage == "reduce" {
+		counter.Reduce += value
+	} else {
+		counter.Map += value
+	}
+	counter.Total += value
+	g[name] = counter
+}
+
+// Records holds the number of lines read and written by a stage.
+type Records struct {
+	In  int
+	Out int
+}
+
+var counterPrefix = "reporter:counter:"
+
+// parseCounter parses a line in the hadoop streaming counter format, e.g. reporter:counter:GROUP,name,1.
+func parseCounter(line string) (group, name string, value int, ok bool) {
+	if !strings.HasPrefix(line, counterPrefix) {
+		return "", "", 0, false
+	}
+
+	parts := strings.Split(line[len(counterPrefix):], ",")
+	if len(parts) != 3 {
+		return "", "", 0, false
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+	if err != nil {
+		return "", "", 0, false
+	}
+	return parts[0], parts[1], value, true
+}
+
+// captureWriter collects counters and app log lines written to stderr by a task.
+type captureWriter struct {
+	mu sync.Mutex
+
+	stage    string
+	counters Counters
+	logs     []string
+	partial  []byte
+}
+
+func newCaptureWriter() *captureWriter {
+	return &captureWriter{counters: Counters{}}
+}
+
+func (c *captureWriter) setStage(stage string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stage = stage
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.partial = append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(c.partial, '\n')
+		if i < 0 {
+			break
+		}
+		c.writeLine(string(c.partial[:i]))
+		c.partial = c.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush handles an unterminated last line written by a task.
+func (c *captureWriter) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.partial) > 0 {
+		c.writeLine(string(c.partial))
+		c.partial = nil
+	}
+}
+
+func (c *captureWriter) writeLine(line string) {
+	if group, name, value, ok := parseCounter(line); ok {
+		c.counters.add(c.stage, group, name, value)
+	} else if strings.HasPrefix(line, job.MRLogPrefix) {
+		c.logs = append(c.logs, line[len(job.MRLogPrefix):])
+	}
+}
+
+// globalMu serializes simulated runs since counters, logs and the environment are process wide.
+var globalMu sync.Mutex
+
+// captureGlobals redirects job counters and logs to the writer and returns a function restoring them.
+func captureGlobals(c io.Writer) func() {
+	pipe := hooks.CounterWriter
+	hooks.CounterWriter = c
+
+	var logOutput io.Writer = os.Stderr
+	if l, ok := job.Log.(interface {
+		Writer() io.Writer
+	}); ok {
+		logOutput = l.Writer()
+	}
+	job.Log.SetOutput(c)
+
+	return func() {
+		hooks.CounterWriter = pipe
+		job.Log.SetOutput(logOutput)
+	}
+}
+
+type countingReader struct {
+	r       io.Reader
+	records *int
+	last    byte
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		*cr.records += bytes.Count(p[:n], []byte{'\n'})
+		cr.last = p[n-1]
+	}
+	if err == io.EOF && cr.last != 0 && cr.last != '\n' {
+		// unterminated last line
+		*cr.records++
+		cr.last = '\n'
+	}
+	return n, err
+}
+
+type countingWriter struct {
+	w       io.Writer
+	records *int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	*cw.records += bytes.Count(p[:n], []byte{'\n'})
+	return n, err
+}
@@ -0,0 +1,113 @@
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+
+	"github.com/Zemanta/mrgob/job"
+)
+
+// CheckError describes a run whose output differs from the output of the reference run.
+type CheckError struct {
+	// Description of the run.
+	Run string
+	// Line diff of the reference output and the output of the run.
+	Diff string
+}
+
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("Output of %s differs from the reference run:\n%s", e.Run, e.Diff)
+}
+
+// bufferedInput holds an input read into memory.
+type bufferedInput struct {
+	filename string
+	data     []byte
+}
+
+// bufferInputs reads the inputs into memory so the job can be run over them multiple times.
+func bufferInputs(input []io.Reader) ([]*bufferedInput, error) {
+	inputs := make([]*bufferedInput, len(input))
+	for i, in := range input {
+		bi := &bufferedInput{}
+		if r, ok := in.(*Reader); ok {
+			bi.filename = r.Filename
+		}
+
+		data, err := ioutil.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		bi.data = data
+		inputs[i] = bi
+	}
+	return inputs, nil
+}
+
+// readers returns fresh readers of the buffered inputs in the provided order.
+func readers(inputs []*bufferedInput, order []int) []io.Reader {
+	rs := make([]io.Reader, len(order))
+	for i, idx := range order {
+		rs[i] = &Reader{Filename: inputs[idx].filename, Data: bytes.NewReader(inputs[idx].data)}
+	}
+	return rs
+}
+
+// copyOptions returns a copy of the options which keeps the output in memory.
+func copyOptions(opts *Options) *Options {
+	o := &Options{}
+	if opts != nil {
+		*o = *opts
+	}
+	o.OutputDir = ""
+	return o
+}
+
+var checkReducers = []int{1, 2, 3, 5, 8}
+
+// CheckDeterminism runs the job over the input a number of times, each time with a different order of the inputs, a different order of values
+// of equal keys and a different number of reducers, and compares the sorted output with the output of a run with the unchanged options.
+// Run n is seeded with n, so failing runs can be reproduced. A *CheckError describing the first differing run is returned if the outputs differ.
+func CheckDeterminism(j *job.Job, input []io.Reader, opts *Options, runs int) error {
+	inputs, err := bufferInputs(input)
+	if err != nil {
+		return err
+	}
+
+	order := make([]int, len(inputs))
+	for i := range order {
+		order[i] = i
+	}
+
+	res, err := Run(j, readers(inputs, order), copyOptions(opts))
+	if err != nil {
+		return err
+	}
+	expected := SortedLines(res.Output())
+
+	for n := 1; n <= runs; n++ {
+		rnd := rand.New(rand.NewSource(int64(n)))
+
+		o := copyOptions(opts)
+		o.Reducers = checkReducers[rnd.Intn(len(checkReducers))]
+		o.ValueOrderSeed = int64(n)
+		order := rnd.Perm(len(inputs))
+
+		res, err := Run(j, readers(inputs, order), o)
+		if err != nil {
+			return err
+		}
+
+		if diff := DiffLines(expected, SortedLines(res.Output())); diff != "" {
+			return &CheckError{
+				Run:  fmt.Sprintf("run %d (input order %v, %d reducers, value order seed %d)", n, order, o.Reducers, o.ValueOrderSeed),
+				Diff: diff,
+			}
+		}
+	}
+
+	return nil
+}
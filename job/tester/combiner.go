@@ -0,0 +1,142 @@
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"github.com/Zemanta/mrgob/job"
+	"github.com/Zemanta/mrgob/job/internal/sorter"
+)
+
+var ErrMissingCombiner = fmt.Errorf("Job has no combiner")
+
+// combinerRunner applies the combiner to the mapper output a fixed number of times, each time over random groups of lines,
+// the same way hadoop may combine each spill and again while merging the spills.
+type combinerRunner struct {
+	*jobRunner
+
+	times  int
+	groups int
+	rnd    *rand.Rand
+	config *sorter.Config
+}
+
+func (cr *combinerRunner) hasCombiner() bool {
+	return false
+}
+
+func (cr *combinerRunner) runTask(stage string, env map[string]string, w io.Writer, r io.Reader) error {
+	if stage != "mapper" || cr.times == 0 {
+		return cr.jobRunner.runTask(stage, env, w, r)
+	}
+
+	out := &bytes.Buffer{}
+	if err := cr.jobRunner.runTask(stage, env, out, r); err != nil {
+		return err
+	}
+
+	lines := splitLines(out.Bytes())
+	for i := 0; i < cr.times; i++ {
+		combined, err := cr.combine(lines)
+		if err != nil {
+			return err
+		}
+		lines = combined
+	}
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// combine splits the lines into random groups keeping their order and runs the combiner over each sorted group.
+func (cr *combinerRunner) combine(lines []string) ([]string, error) {
+	groups := make([]*sorter.Sorter, cr.groups)
+	for i := range groups {
+		groups[i] = sorter.New(cr.config)
+	}
+	defer closeSorters(groups)
+
+	for _, line := range lines {
+		if _, err := io.WriteString(groups[cr.rnd.Intn(len(groups))], line+"\n"); err != nil {
+			return nil, err
+		}
+	}
+
+	out := &bytes.Buffer{}
+	for _, s := range groups {
+		sorted, err := s.Sort()
+		if err != nil {
+			return nil, err
+		}
+		if err := cr.j.Run("combiner", out, sorted); err != nil {
+			return nil, err
+		}
+	}
+	return splitLines(out.Bytes()), nil
+}
+
+// CheckCombiner checks that the reducer output doesn't depend on how many times the combiner is applied. The output of a run without the combiner
+// is compared with runs applying the combiner once to the whole mapper output and with runs applying it several times over random groups
+// of lines, like hadoop does when combining spills. Run n is seeded with n, so failing runs can be reproduced.
+// A *CheckError with the diff of the outputs is returned for the first differing run.
+func CheckCombiner(j *job.Job, input []io.Reader, opts *Options, runs int) error {
+	if !j.HasCombiner() {
+		return ErrMissingCombiner
+	}
+
+	inputs, err := bufferInputs(input)
+	if err != nil {
+		return err
+	}
+	order := make([]int, len(inputs))
+	for i := range order {
+		order[i] = i
+	}
+
+	cfg, err := opts.sorterConfig()
+	if err != nil {
+		return err
+	}
+	o := copyOptions(opts)
+
+	res, err := runInProcess(&combinerRunner{jobRunner: &jobRunner{j}}, readers(inputs, order), o)
+	if err != nil {
+		return err
+	}
+	expected := res.Output()
+
+	for n := 0; n <= runs; n++ {
+		cr := &combinerRunner{
+			jobRunner: &jobRunner{j},
+			times:     1,
+			groups:    1,
+			rnd:       rand.New(rand.NewSource(int64(n))),
+			config:    cfg,
+		}
+		// run 0 combines the whole mapper output once
+		if n > 0 {
+			cr.times = cr.rnd.Intn(3) + 1
+			cr.groups = cr.rnd.Intn(4) + 1
+		}
+
+		res, err := runInProcess(cr, readers(inputs, order), o)
+		if err != nil {
+			return err
+		}
+
+		if diff := DiffLines(expected, res.Output()); diff != "" {
+			return &CheckError{
+				Run:  fmt.Sprintf("run %d (combiner applied %d times over %d groups)", n, cr.times, cr.groups),
+				Diff: diff,
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+var (
+	// Maximum number of compared line pairs before diff stops looking for common lines.
+	maxDiffCost = 1 << 22
+	// Maximum number of changed lines included in the diff.
+	maxDiffLines = 100
+)
+
+// splitLines splits the output into lines without the trailing new lines.
+func splitLines(b []byte) []string {
+	b = bytes.TrimSuffix(b, []byte{'\n'})
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(string(b), "\n")
+}
+
+// DiffLines returns a line-oriented diff of the expected and the actual output. Lines missing from the actual output are prefixed with "-",
+// unexpected lines with "+". The diff is empty when the outputs are equal.
+func DiffLines(expected, actual []byte) string {
+	return diffLines(splitLines(expected), splitLines(actual))
+}
+
+func diffLines(a, b []string) string {
+	// common prefix and suffix
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	ea, eb := len(a), len(b)
+	for ea > start && eb > start && a[ea-1] == b[eb-1] {
+		ea--
+		eb--
+	}
+	a, b = a[start:ea], b[start:eb]
+
+	if len(a) == 0 && len(b) == 0 {
+		return ""
+	}
+
+	lines := []string{}
+	add := func(prefix string, line int, s string) {
+		lines = append(lines, fmt.Sprintf("%s%d: %s", prefix, start+line+1, s))
+	}
+
+	if len(a)*len(b) > maxDiffCost {
+		for i, s := range a {
+			add("-", i, s)
+		}
+		for i, s := range b {
+			add("+", i, s)
+		}
+		return formatDiff(lines)
+	}
+
+	// longest common subsequence of the remaining lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			add("-", i, a[i])
+			i++
+		default:
+			add("+", j, b[j])
+			j++
+		}
+	}
+	return formatDiff(lines)
+}
+
+func formatDiff(lines []string) string {
+	if len(lines) > maxDiffLines {
+		more := len(lines) - maxDiffLines
+		lines = append(lines[:maxDiffLines], fmt.Sprintf("... %d more changed lines", more))
+	}
+	return strings.Join(lines, "\n")
+}
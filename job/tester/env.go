@@ -0,0 +1,67 @@
+package tester
+
+import (
+	"os"
+
+	"github.com/Zemanta/mrgob/job"
+	"github.com/Zemanta/mrgob/job/internal/environ"
+)
+
+// setJobEnv sets the environment variables and the job config of the options the same way as the runner passes them to the job.
+// It returns a function restoring the previous environment.
+func (o *Options) setJobEnv() (func(), error) {
+	// task environment is set by each simulated task
+	restore := []func(){}
+	for _, k := range taskEnvKeys {
+		restore = append(restore, environ.Set(k, ""))
+	}
+	undo := func() {
+		for i := len(restore) - 1; i >= 0; i-- {
+			restore[i]()
+		}
+	}
+
+	env, err := o.jobEnv()
+	if err != nil {
+		undo()
+		return nil, err
+	}
+	for k, v := range env {
+		restore = append(restore, environ.Set(k, v))
+	}
+
+	return undo, nil
+}
+
+// jobEnv returns the environment variables and the encoded job config of the options.
+func (o *Options) jobEnv() (map[string]string, error) {
+	env := map[string]string{}
+	if o == nil {
+		return env, nil
+	}
+
+	for k, v := range o.Env {
+		env[k] = v
+	}
+
+	if o.JobConfig != nil {
+		config, err := job.EncodeConfig(o.JobConfig)
+		if err != nil {
+			return nil, err
+		}
+		env[job.ConfigEnv] = config
+	}
+
+	return env, nil
+}
+
+// setTaskEnv sets the environment of a simulated task. Task variables missing from env are cleared, so a task doesn't see
+// the environment of the previous one, e.g. reducers don't see the input file of the last mapper.
+func setTaskEnv(env map[string]string) {
+	for _, k := range taskEnvKeys {
+		os.Setenv(k, "")
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+}
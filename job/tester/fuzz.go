@@ -0,0 +1,71 @@
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime/debug"
+
+	"github.com/Zemanta/mrgob/job"
+)
+
+// PanicError holds a recovered panic of a job function.
+type PanicError struct {
+	// Stage of the panicking task, mapper, combiner or reducer.
+	Stage string
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s panicked: %v\n%s", e.Stage, e.Value, e.Stack)
+}
+
+// recoverRunner returns panics of tasks as errors.
+type recoverRunner struct {
+	taskRunner
+}
+
+func (rr *recoverRunner) runTask(stage string, env map[string]string, w io.Writer, r io.Reader) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Stage: stage, Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return rr.taskRunner.runTask(stage, env, w, r)
+}
+
+// Invariant checks the result of a job run over the input and returns an error if it's violated.
+type Invariant func(input []byte, res *Result) error
+
+// EachOutputLine returns an invariant checking each output line of the job without the trailing new line.
+func EachOutputLine(check func(line []byte) error) Invariant {
+	return func(input []byte, res *Result) error {
+		for i, line := range bytes.SplitAfter(res.Output(), []byte{'\n'}) {
+			if len(line) == 0 {
+				continue
+			}
+			if err := check(bytes.TrimSuffix(line, []byte{'\n'})); err != nil {
+				return fmt.Errorf("Output line %d %q: %s", i+1, line, err)
+			}
+		}
+		return nil
+	}
+}
+
+// Fuzz runs the job over the fuzzed input and checks the result with the invariants. It's meant to be called from native go fuzz targets.
+// Panics of the mapper, combiner and reducer are recovered and returned as *PanicError holding the stage and the stack trace.
+// Jobs calling job.Log.Fatal still exit the fuzzing process.
+func Fuzz(j *job.Job, input []byte, opts *Options, invariants ...Invariant) error {
+	res, err := runInProcess(&recoverRunner{&jobRunner{j}}, []io.Reader{bytes.NewReader(input)}, copyOptions(opts))
+	if err != nil {
+		return err
+	}
+
+	for _, inv := range invariants {
+		if err := inv(input, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package tester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Zemanta/mrgob/job"
+)
+
+// UpdateGolden makes CheckGolden write the output to the golden files instead of comparing it. Tests usually set it with their own flag,
+// e.g. flag.BoolVar(&tester.UpdateGolden, "update", false, "update golden files").
+var UpdateGolden bool
+
+// GoldenDir is the directory holding fixture inputs and golden files with relative paths.
+var GoldenDir = "testdata"
+
+func goldenPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(GoldenDir, path)
+}
+
+// SortedLines normalizes the output by sorting its lines, for jobs whose output order doesn't matter.
+func SortedLines(output []byte) []byte {
+	lines := splitLines(output)
+	sort.Strings(lines)
+
+	out := &bytes.Buffer{}
+	for _, line := range lines {
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// CanonicalJSON normalizes each tab separated field of the output that is valid json by re-encoding it with sorted object keys and without whitespace.
+func CanonicalJSON(output []byte) []byte {
+	out := &bytes.Buffer{}
+	for _, line := range splitLines(output) {
+		for i, field := range bytes.Split([]byte(line), []byte{'\t'}) {
+			if i > 0 {
+				out.WriteByte('\t')
+			}
+
+			// numbers are kept as they are, large integers would lose precision as float64
+			var v interface{}
+			dec := json.NewDecoder(bytes.NewReader(field))
+			dec.UseNumber()
+			if err := dec.Decode(&v); err != nil || dec.More() {
+				out.Write(field)
+				continue
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				out.Write(field)
+				continue
+			}
+			out.Write(b)
+		}
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// goldenInputs opens the fixture files matching the patterns.
+func goldenInputs(patterns []string) ([]io.Reader, func(), error) {
+	files := []*os.File{}
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	input := []io.Reader{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(goldenPath(pattern))
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+		if len(matches) == 0 {
+			closeFiles()
+			return nil, nil, fmt.Errorf("No fixture files match %s", goldenPath(pattern))
+		}
+
+		for _, fn := range matches {
+			f, err := os.Open(fn)
+			if err != nil {
+				closeFiles()
+				return nil, nil, err
+			}
+			files = append(files, f)
+			input = append(input, &Reader{Filename: fn, Data: f})
+		}
+	}
+	return input, closeFiles, nil
+}
+
+// CheckGolden runs the job over the fixture files matching the input patterns and compares the output, normalized by the normalize functions,
+// with the golden file. Relative paths are resolved in GoldenDir and fixture file names are passed to mappers like with tester.Reader.
+// When UpdateGolden is set, the golden file is written instead. A line diff is returned in the error if the output differs.
+func CheckGolden(j *job.Job, inputs []string, golden string, opts *Options, normalize ...func([]byte) []byte) error {
+	input, closeInputs, err := goldenInputs(inputs)
+	if err != nil {
+		return err
+	}
+	defer closeInputs()
+
+	res, err := Run(j, input, copyOptions(opts))
+	if err != nil {
+		return err
+	}
+
+	return CompareGolden(res.Output(), golden, normalize...)
+}
+
+// CompareGolden compares the normalized output with the golden file, or writes it to the golden file when UpdateGolden is set.
+func CompareGolden(output []byte, golden string, normalize ...func([]byte) []byte) error {
+	for _, n := range normalize {
+		output = n(output)
+	}
+
+	path := goldenPath(golden)
+	if UpdateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, output, 0644)
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Missing golden file %s, run the test with UpdateGolden set to create it", path)
+	} else if err != nil {
+		return err
+	}
+
+	if diff := DiffLines(expected, output); diff != "" {
+		return fmt.Errorf("Output differs from golden file %s, run the test with UpdateGolden set if the change is expected:\n%s", path, diff)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+
+	"github.com/Zemanta/mrgob/job"
+)
+
+// Stage is a single job of a pipeline.
+type Stage struct {
+	// Name of the stage, used to look up its result and as the input directory of the next stages, e.g. wordcount/part-00000.
+	Name string
+	Job  *job.Job
+	// Options of the simulated run of the stage, including its job config and number of reducers.
+	Options *Options
+	// Names of the earlier stages whose output is the input of the stage. Defaults to the previous stage,
+	// the first stage reads the pipeline input.
+	Inputs []string
+}
+
+// PipelineResult holds the results of all the stages of a pipeline.
+type PipelineResult struct {
+	Stages []*Result
+
+	names map[string]int
+}
+
+// Stage returns the result of the stage with the name or nil if there's no such stage.
+func (r *PipelineResult) Stage(name string) *Result {
+	i, ok := r.names[name]
+	if !ok {
+		return nil
+	}
+	return r.Stages[i]
+}
+
+// Output returns the output of the last stage.
+func (r *PipelineResult) Output() []byte {
+	if len(r.Stages) == 0 {
+		return nil
+	}
+	return r.Stages[len(r.Stages)-1].Output()
+}
+
+// partitionReaders returns readers of the partitions of the stage named after its output files.
+func partitionReaders(name string, res *Result) ([]io.Reader, error) {
+	rs := []io.Reader{}
+	for _, p := range res.Partitions {
+		data := p.Output
+		if p.Path != "" {
+			var err error
+			if data, err = ioutil.ReadFile(p.Path); err != nil {
+				return nil, err
+			}
+		}
+		rs = append(rs, &Reader{Filename: path.Join(name, p.Name), Data: bytes.NewReader(data)})
+	}
+	return rs, nil
+}
+
+// RunPipeline runs the stages one after another the same way as Run does, each stage reading the reducer output of its input stages.
+// Each partition of an input stage is read by a separate mapper. Results of all the stages are returned so intermediate outputs,
+// counters and logs can be checked. The results of the finished stages are returned along with the error of a failed stage.
+func RunPipeline(stages []*Stage, input []io.Reader) (*PipelineResult, error) {
+	res := &PipelineResult{names: map[string]int{}}
+
+	for i, s := range stages {
+		if _, ok := res.names[s.Name]; ok {
+			return res, fmt.Errorf("Duplicate stage %q", s.Name)
+		}
+
+		in := input
+		if len(s.Inputs) > 0 {
+			in = []io.Reader{}
+			for _, name := range s.Inputs {
+				idx, ok := res.names[name]
+				if !ok {
+					return res, fmt.Errorf("Stage %q: unknown input stage %q", s.Name, name)
+				}
+				rs, err := partitionReaders(name, res.Stages[idx])
+				if err != nil {
+					return res, fmt.Errorf("Stage %q: %s", s.Name, err)
+				}
+				in = append(in, rs...)
+			}
+		} else if i > 0 {
+			prev := stages[i-1].Name
+			rs, err := partitionReaders(prev, res.Stages[i-1])
+			if err != nil {
+				return res, fmt.Errorf("Stage %q: %s", s.Name, err)
+			}
+			in = rs
+		}
+
+		r, err := Run(s.Job, in, s.Options)
+		if err != nil {
+			return res, fmt.Errorf("Stage %q: %s", s.Name, err)
+		}
+		res.names[s.Name] = len(res.Stages)
+		res.Stages = append(res.Stages, r)
+	}
+
+	return res, nil
+}
@@ -2,7 +2,6 @@ package tester
 
 import (
 	"io"
-	"os"
 )
 
 type Reader struct {
@@ -14,13 +13,10 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 	return r.Data.Read(p)
 }
 
-func setReaderEnv(rawr io.Reader) {
-	os.Setenv("mapreduce_map_input_file", "")
-
-	r, ok := rawr.(*Reader)
-	if !ok {
-		return
+// readerFilename returns the filename of the input reader.
+func readerFilename(r io.Reader) string {
+	if tr, ok := r.(*Reader); ok {
+		return tr.Filename
 	}
-
-	os.Setenv("mapreduce_map_input_file", r.Filename)
+	return ""
 }
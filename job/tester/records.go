@@ -0,0 +1,202 @@
+package tester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Zemanta/mrgob/job"
+)
+
+// Record is a decoded output line.
+type Record struct {
+	Key   interface{}
+	Value interface{}
+}
+
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%#v", v)
+	}
+	return string(b)
+}
+
+// String formats the key and the value as json separated by a tab, the same way as they are shown in diffs.
+func (r *Record) String() string {
+	return formatValue(r.Key) + "\t" + formatValue(r.Value)
+}
+
+// lineReader returns a reader of the line terminated by a new line without modifying the line.
+func lineReader(line []byte) io.Reader {
+	return io.MultiReader(bytes.NewReader(line), bytes.NewReader([]byte{'\n'}))
+}
+
+// Decoder decodes an output line into a record.
+type Decoder func(line []byte) (*Record, error)
+
+// ByteDecoder decodes lines written with job.ByteKVWriter into records with string keys and values.
+func ByteDecoder(line []byte) (*Record, error) {
+	r := job.NewByteKVReader(lineReader(line))
+	if !r.Scan() {
+		return nil, r.Err()
+	}
+
+	key, vr := r.Key()
+	rec := &Record{Key: string(key), Value: ""}
+	if vr.Scan() {
+		rec.Value = string(vr.Value())
+	}
+	return rec, nil
+}
+
+func isPtr(prototype interface{}) bool {
+	return prototype != nil && reflect.TypeOf(prototype).Kind() == reflect.Ptr
+}
+
+// newTarget allocates a value of the prototype's type to decode into. Nil prototypes decode into interface{}.
+func newTarget(prototype interface{}) reflect.Value {
+	if prototype == nil {
+		return reflect.New(reflect.TypeOf((*interface{})(nil)).Elem())
+	}
+	if isPtr(prototype) {
+		return reflect.New(reflect.TypeOf(prototype).Elem())
+	}
+	return reflect.New(reflect.TypeOf(prototype))
+}
+
+// decoded returns the decoded value, a pointer if the prototype is a pointer.
+func decoded(prototype interface{}, target reflect.Value) interface{} {
+	if isPtr(prototype) {
+		return target.Interface()
+	}
+	return target.Elem().Interface()
+}
+
+// JsonDecoder returns a decoder of lines written with job.JsonKVWriter. Keys and values are decoded into new values of the same types
+// as the key and value prototypes, e.g. JsonDecoder("", &Count{}) returns records with string keys and *Count values.
+// Nil prototypes decode into interface{}.
+func JsonDecoder(key, value interface{}) Decoder {
+	return func(line []byte) (*Record, error) {
+		r := job.NewJsonKVReader(lineReader(line))
+		if !r.Scan() {
+			return nil, r.Err()
+		}
+
+		k := newTarget(key)
+		vr, err := r.Key(k.Interface())
+		if err != nil {
+			return nil, err
+		}
+		rec := &Record{Key: decoded(key, k)}
+
+		// lines written with WriteKey have no value
+		if bytes.IndexByte(line, '\t') >= 0 && vr.Scan() {
+			v := newTarget(value)
+			if err := vr.Value(v.Interface()); err != nil {
+				return nil, err
+			}
+			rec.Value = decoded(value, v)
+		}
+		return rec, nil
+	}
+}
+
+// Decode decodes each line of the output into a record. Empty lines are skipped.
+func Decode(output []byte, dec Decoder) ([]*Record, error) {
+	records := []*Record{}
+	for i, line := range splitLines(output) {
+		if line == "" {
+			continue
+		}
+		rec, err := dec([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", i+1, err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// Records decodes the output of the run into records.
+func (r *Result) Records(dec Decoder) ([]*Record, error) {
+	return Decode(r.Output(), dec)
+}
+
+// recordLines returns the formatted records as sorted lines.
+func recordLines(records []*Record) []string {
+	lines := make([]string, len(records))
+	for i, r := range records {
+		lines[i] = r.String()
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// MatchRecords checks that the records are equal to the expected ones regardless of their order.
+// Records are compared by their json encoding and the error holds a diff of the sorted records.
+func MatchRecords(expected, actual []*Record) error {
+	if diff := diffLines(recordLines(expected), recordLines(actual)); diff != "" {
+		return fmt.Errorf("Records don't match:\n%s", diff)
+	}
+	return nil
+}
+
+// ContainsRecords checks that all the expected records are among the actual ones, each expected record matching a different actual record.
+func ContainsRecords(expected, actual []*Record) error {
+	counts := map[string]int{}
+	for _, line := range recordLines(actual) {
+		counts[line]++
+	}
+
+	missing := []string{}
+	for _, line := range recordLines(expected) {
+		if counts[line] == 0 {
+			missing = append(missing, "-"+line)
+			continue
+		}
+		counts[line]--
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("Missing %d of %d expected records:\n%s", len(missing), len(expected), formatDiff(missing))
+	}
+	return nil
+}
+
+// MatchKeys checks that the values of each key of the expected records match the actual values of the key regardless of their order.
+// Keys without expected records are ignored.
+func MatchKeys(expected, actual []*Record) error {
+	group := func(records []*Record) map[string][]*Record {
+		g := map[string][]*Record{}
+		for _, r := range records {
+			k := formatValue(r.Key)
+			g[k] = append(g[k], r)
+		}
+		return g
+	}
+	exp := group(expected)
+	act := group(actual)
+
+	keys := []string{}
+	for k := range exp {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	diffs := []string{}
+	for _, k := range keys {
+		if diff := diffLines(recordLines(exp[k]), recordLines(act[k])); diff != "" {
+			diffs = append(diffs, fmt.Sprintf("key %s:\n%s", k, diff))
+		}
+	}
+
+	if len(diffs) > 0 {
+		return fmt.Errorf("Values of %d keys don't match:\n%s", len(diffs), strings.Join(diffs, "\n"))
+	}
+	return nil
+}
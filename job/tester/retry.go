@@ -0,0 +1,239 @@
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/Zemanta/mrgob/job"
+	"github.com/Zemanta/mrgob/job/internal/hooks"
+)
+
+var ErrInjectedFailure = fmt.Errorf("Injected task failure")
+
+// Failure injects failures into the first attempts of mapper or reducer tasks. Combiners are never retried.
+type Failure struct {
+	// Stage of the failing tasks, mapper or reducer. Failures of the combiner stage aren't supported.
+	Stage string
+	// Index of the failing task. All tasks of the stage fail when negative.
+	Task int
+	// Number of input records the attempt reads before it fails.
+	AfterRecords int
+	// Panic while reading the input instead of returning ErrInjectedFailure from the reader.
+	Panic bool
+	// Number of failing attempts of each task. Defaults to 1.
+	Attempts int
+}
+
+func (f *Failure) attempts() int {
+	if f.Attempts <= 0 {
+		return 1
+	}
+	return f.Attempts
+}
+
+// Attempt describes a task attempt of a run with injected failures or speculative attempts.
+type Attempt struct {
+	Stage   string
+	Task    int
+	Attempt int
+	// Error of a failed attempt.
+	Err error
+	// Speculative duplicate of a successful attempt.
+	Speculative bool
+	// Output and counters of the attempt were committed.
+	Committed bool
+}
+
+func (o *Options) retries() bool {
+	return o != nil && (len(o.Failures) > 0 || o.Speculative)
+}
+
+func (o *Options) maxAttempts() int {
+	if o.MaxAttempts <= 0 {
+		return 4
+	}
+	return o.MaxAttempts
+}
+
+func (o *Options) failure(stage string, task int) *Failure {
+	for _, f := range o.Failures {
+		if f.Stage == stage && (f.Task < 0 || f.Task == task) {
+			return f
+		}
+	}
+	return nil
+}
+
+// failingReader fails the attempt after the number of records was read.
+type failingReader struct {
+	r     io.Reader
+	after int
+	panic bool
+
+	records int
+	failed  bool
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if fr.records >= fr.after {
+		fr.failed = true
+		if fr.panic {
+			panic(ErrInjectedFailure)
+		}
+		return 0, ErrInjectedFailure
+	}
+
+	n, err := fr.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] != '\n' {
+			continue
+		}
+		fr.records++
+		if fr.records >= fr.after {
+			// the rest of the input is never read
+			return i + 1, nil
+		}
+	}
+	return n, err
+}
+
+// retryRunner runs mapper and reducer tasks in attempts, the same way as hadoop does. Output and counters of an attempt are committed only
+// if it succeeds, failed attempts are retried up to the maximum number of attempts.
+type retryRunner struct {
+	taskRunner
+
+	opts     *Options
+	capture  *captureWriter
+	attempts []*Attempt
+}
+
+func attemptEnv(env map[string]string, attempt int) map[string]string {
+	e := map[string]string{}
+	for k, v := range env {
+		e[k] = v
+	}
+	task, _ := strconv.Atoi(env[job.TaskPartitionEnv])
+	e[job.TaskAttemptEnv] = taskEnv(env[job.TaskIsMapEnv] == "true", task, attempt)[job.TaskAttemptEnv]
+	return e
+}
+
+func (rr *retryRunner) runTask(stage string, env map[string]string, w io.Writer, r io.Reader) error {
+	// combiners run once over the committed output of the mapper attempt, they're never failed or retried
+	// and their counters are captured directly
+	if stage == "combiner" {
+		return rr.taskRunner.runTask(stage, env, w, r)
+	}
+
+	input, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	task, _ := strconv.Atoi(env[job.TaskPartitionEnv])
+	failure := rr.opts.failure(stage, task)
+
+	for attempt := 0; ; attempt++ {
+		a := &Attempt{Stage: stage, Task: task, Attempt: attempt}
+		rr.attempts = append(rr.attempts, a)
+
+		var in io.Reader = bytes.NewReader(input)
+		if failure != nil && attempt < failure.attempts() {
+			in = &failingReader{r: in, after: failure.AfterRecords, panic: failure.Panic}
+		}
+
+		out, counters, err := rr.runAttempt(stage, attemptEnv(env, attempt), in)
+		if err != nil {
+			a.Err = err
+			if attempt+1 >= rr.opts.maxAttempts() {
+				return err
+			}
+			continue
+		}
+
+		if rr.opts.Speculative {
+			sa := &Attempt{Stage: stage, Task: task, Attempt: attempt + 1, Speculative: true}
+			_, _, sa.Err = rr.runAttempt(stage, attemptEnv(env, attempt+1), bytes.NewReader(input))
+			rr.attempts = append(rr.attempts, sa)
+		}
+
+		a.Committed = true
+		rr.capture.Write(counters)
+		_, err = w.Write(out)
+		return err
+	}
+}
+
+// runAttempt runs a task attempt, returning its output and counters. Panics fail the attempt.
+func (rr *retryRunner) runAttempt(stage string, env map[string]string, r io.Reader) ([]byte, []byte, error) {
+	out := &bytes.Buffer{}
+	counters := &bytes.Buffer{}
+
+	hooks.CounterWriter = counters
+	defer func() { hooks.CounterWriter = rr.capture }()
+
+	err := (&recoverRunner{rr.taskRunner}).runTask(stage, env, out, r)
+	// the job may ignore the read error
+	if fr, ok := r.(*failingReader); ok && fr.failed && err == nil {
+		err = ErrInjectedFailure
+	}
+	return out.Bytes(), counters.Bytes(), err
+}
+
+// countersLines returns the total values of the counters as sorted lines.
+func countersLines(c Counters) []byte {
+	lines := []string{}
+	for group, g := range c {
+		for name, counter := range g {
+			lines = append(lines, fmt.Sprintf("%s,%s: %d", group, name, counter.Total))
+		}
+	}
+	sort.Strings(lines)
+
+	out := &bytes.Buffer{}
+	for _, line := range lines {
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// CheckRetries runs the job with the injected failures and speculative attempts of the options and compares its output and counters
+// with a clean run without them. Differences show that side effects of the job aren't idempotent or that the job depends on attempts.
+// A *CheckError with the diff is returned if they differ.
+func CheckRetries(j *job.Job, input []io.Reader, opts *Options) error {
+	inputs, err := bufferInputs(input)
+	if err != nil {
+		return err
+	}
+	order := make([]int, len(inputs))
+	for i := range order {
+		order[i] = i
+	}
+
+	clean := copyOptions(opts)
+	clean.Failures = nil
+	clean.Speculative = false
+
+	expected, err := Run(j, readers(inputs, order), clean)
+	if err != nil {
+		return err
+	}
+
+	res, err := Run(j, readers(inputs, order), copyOptions(opts))
+	if err != nil {
+		return err
+	}
+
+	run := fmt.Sprintf("run with %d task attempts", len(res.Attempts))
+	if diff := DiffLines(expected.Output(), res.Output()); diff != "" {
+		return &CheckError{Run: run, Diff: diff}
+	}
+	if diff := DiffLines(countersLines(expected.Counters), countersLines(res.Counters)); diff != "" {
+		return &CheckError{Run: run + " counters", Diff: diff}
+	}
+	return nil
+}
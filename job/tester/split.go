@@ -0,0 +1,124 @@
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/Zemanta/mrgob/job"
+)
+
+// Remaining input up to this many split sizes is kept in the last split, the same as hadoop's FileInputFormat SPLIT_SLOP.
+var splitSlop = 1.1
+
+// taskEnvKeys are the environment variables describing the running task.
+var taskEnvKeys = []string{
+	job.InputFileEnv,
+	job.InputStartEnv,
+	job.InputLengthEnv,
+	job.TaskPartitionEnv,
+	job.TaskIsMapEnv,
+	job.TaskAttemptEnv,
+}
+
+// taskEnv returns the environment of the task attempt.
+func taskEnv(isMap bool, partition, attempt int) map[string]string {
+	kind := "r"
+	if isMap {
+		kind = "m"
+	}
+	return map[string]string{
+		job.TaskPartitionEnv: strconv.Itoa(partition),
+		job.TaskIsMapEnv:     strconv.FormatBool(isMap),
+		job.TaskAttemptEnv:   fmt.Sprintf("attempt_local_0001_%s_%06d_%d", kind, partition, attempt),
+	}
+}
+
+// mapTask holds the input split of a mapper.
+type mapTask struct {
+	filename      string
+	start, length int
+	input         io.Reader
+}
+
+func (t *mapTask) env(partition, attempt int) map[string]string {
+	env := taskEnv(true, partition, attempt)
+	env[job.InputFileEnv] = t.filename
+	env[job.InputStartEnv] = strconv.Itoa(t.start)
+	// the length of inputs which aren't split isn't known upfront
+	if t.length >= 0 {
+		env[job.InputLengthEnv] = strconv.Itoa(t.length)
+	}
+	return env
+}
+
+// splitRange returns the lines of the split the same way as hadoop's LineRecordReader reads them. A line starting at offset p belongs to the split
+// with start < p <= end, so a line crossing the split boundary belongs to the earlier split and the first line of the input to the first split.
+func splitRange(data []byte, start, end int) []byte {
+	begin := 0
+	if start > 0 {
+		// skip the remainder of the line which belongs to the previous split
+		i := bytes.IndexByte(data[start:], '\n')
+		if i < 0 {
+			return nil
+		}
+		begin = start + i + 1
+	}
+
+	stop := len(data)
+	if end < len(data) {
+		// finish the line which starts at or crosses the end of the split
+		if i := bytes.IndexByte(data[end:], '\n'); i >= 0 {
+			stop = end + i + 1
+		}
+	}
+
+	if begin >= stop {
+		return nil
+	}
+	return data[begin:stop]
+}
+
+// splitInput splits the input into splits of the split size. Empty inputs have a single empty split.
+func splitInput(name string, data []byte, size int) []*mapTask {
+	tasks := []*mapTask{}
+	add := func(start, end int) {
+		tasks = append(tasks, &mapTask{
+			filename: name,
+			start:    start,
+			length:   end - start,
+			input:    bytes.NewReader(splitRange(data, start, end)),
+		})
+	}
+
+	start := 0
+	for float64(len(data)-start)/float64(size) > splitSlop {
+		add(start, start+size)
+		start += size
+	}
+	if start < len(data) || len(tasks) == 0 {
+		add(start, len(data))
+	}
+	return tasks
+}
+
+// mapTasks returns the mapper tasks of the inputs. Inputs are only read upfront when they have to be split.
+func (o *Options) mapTasks(input []io.Reader) ([]*mapTask, error) {
+	tasks := []*mapTask{}
+	if o == nil || o.SplitSize <= 0 {
+		for _, in := range input {
+			tasks = append(tasks, &mapTask{filename: readerFilename(in), length: -1, input: in})
+		}
+		return tasks, nil
+	}
+
+	inputs, err := bufferInputs(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range inputs {
+		tasks = append(tasks, splitInput(in.filename, in.data, o.SplitSize)...)
+	}
+	return tasks, nil
+}
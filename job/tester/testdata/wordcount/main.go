@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Zemanta/mrgob/job"
+)
+
+func mapper(w *job.ByteKVWriter, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		job.Count("lines", 1)
+		for _, word := range strings.Fields(scanner.Text()) {
+			w.Write([]byte(word), []byte("1"))
+		}
+	}
+}
+
+func combiner(w *job.ByteKVWriter, r *job.ByteKVReader) {
+	for r.Scan() {
+		key, vr := r.Key()
+		w.Write(key, []byte(strconv.Itoa(sum(vr))))
+	}
+}
+
+func reducer(w io.Writer, r *job.ByteKVReader) {
+	for r.Scan() {
+		key, vr := r.Key()
+		fmt.Fprintf(w, "%s\t%d\n", key, sum(vr))
+	}
+	job.Log.Print("done")
+}
+
+func sum(vr *job.ByteValueReader) int {
+	s := 0
+	for vr.Scan() {
+		n, _ := strconv.Atoi(string(vr.Value()))
+		s += n
+	}
+	return s
+}
+
+func failingMapper(w *job.ByteKVWriter, r io.Reader) {
+	job.Log.Fatalf("failed reading %s", os.Getenv(job.InputFileEnv))
+}
+
+func main() {
+	job.Register("wordcount", job.NewByteJob(mapper, reducer).WithByteCombiner(combiner))
+	job.Register("fail", job.NewByteJob(failingMapper, reducer))
+	job.Init()
+}
@@ -1,62 +1,449 @@
 package tester
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
 	"io"
-	"sort"
-	"strings"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
 
 	"github.com/Zemanta/mrgob/job"
+	"github.com/Zemanta/mrgob/job/internal/sorter"
 )
 
-type testSorter struct {
-	bytes.Buffer
+// Options configures the simulated mapreduce run.
+type Options struct {
+	// Number of reducers. Defaults to 1.
+	Reducers int
+	// Partitioner returns the reducer for the key of a mapper output line. Defaults to HashPartitioner.
+	Partitioner func(key []byte, reducers int) int
+
+	// Separator between the key and the value in mapper output lines (stream.map.output.field.separator). Defaults to a tab.
+	Separator byte
+	// Number of fields forming the key (stream.num.map.output.key.fields). Defaults to 1.
+	KeyFields int
+	// Key comparator options in the KeyFieldBasedComparator format (mapreduce.partition.keycomparator.options), e.g. "-k1,1 -k2,2nr".
+	// Keys are compared byte-wise by default.
+	KeyComparatorOptions string
+	// Separator of the key fields used by the key comparator options (mapreduce.map.output.key.field.separator). Defaults to a tab.
+	KeyFieldSeparator byte
+
+	// Maximum number of bytes of mapper output each reducer's sorter buffers in memory before spilling sorted lines to temporary files.
+	// The whole mapper output is kept in memory by default.
+	SortMemory int
+	// Directory for the temporary sort files. Defaults to os.TempDir().
+	TempDir string
+	// Directory the reducer output is written to instead of memory, one part-NNNNN file per reducer.
+	OutputDir string
+
+	// Maximum size of mapper input splits in bytes (mapreduce.input.fileinputformat.split.maxsize). When set, inputs are split at line boundaries
+	// the same way as hadoop's TextInputFormat does and each split is processed by a separate mapper. Each input is a single split by default.
+	SplitSize int
+
+	// When non-zero, values of equal keys are passed to combiners and reducers in a random order seeded by it
+	// instead of the order in which they were written.
+	ValueOrderSeed int64
+
+	// Job configuration made available through job.Config, encoded the same way as runner.MapReduceConfig.JobConfig.
+	JobConfig interface{}
+	// Environment variables set for the duration of the run, same as runner.MapReduceConfig.Env.
+	Env map[string]string
+
+	// Failures injected into mapper and reducer task attempts. Failed attempts are retried and their output and counters are discarded.
+	// Combiners aren't retried. Used only by Run.
+	Failures []*Failure
+	// Run a speculative duplicate of each successful mapper and reducer attempt, whose output and counters are discarded. Used only by Run.
+	Speculative bool
+	// Maximum number of attempts of each task (mapreduce.map.maxattempts). Defaults to 4.
+	MaxAttempts int
+
+	// Name of the registered job passed to the job binary with -job. Used only by RunBinary.
+	JobName string
+	// Run the combiner stage of the job binary. Used only by RunBinary, Run uses the job's combiner if it has one.
+	Combiner bool
+}
+
+func (o *Options) reducers() int {
+	if o == nil || o.Reducers <= 0 {
+		return 1
+	}
+	return o.Reducers
 }
 
-func (s *testSorter) sort() {
-	lines := strings.Split(strings.TrimSpace(s.String()), "\n")
-	sort.Strings(lines)
-	s.Reset()
-	s.WriteString(strings.Join(lines, "\n"))
-	s.WriteString("\n")
+func (o *Options) partitioner() func([]byte, int) int {
+	if o == nil || o.Partitioner == nil {
+		return HashPartitioner
+	}
+	return o.Partitioner
 }
 
-// TestRawJob simulates a raw mapreduce job by reading the data from the input reader and writing results to the output writer
-func TestRawJob(input []io.Reader, output io.Writer, mapper func(io.Writer, io.Reader), reducer func(io.Writer, io.Reader)) {
-	sorter := &testSorter{}
+func (o *Options) sorterConfig() (*sorter.Config, error) {
+	cfg := &sorter.Config{
+		// the whole mapper output is kept in memory
+		MemoryLimit: math.MaxInt32,
+	}
+	if o == nil {
+		return cfg, nil
+	}
 
-	for _, in := range input {
-		setReaderEnv(in)
-		mapper(sorter, in)
+	if o.SortMemory > 0 {
+		cfg.MemoryLimit = o.SortMemory
+	}
+	cfg.TempDir = o.TempDir
+	if o.ValueOrderSeed != 0 {
+		cfg.Shuffle = rand.New(rand.NewSource(o.ValueOrderSeed))
 	}
-	sorter.sort()
-	reducer(output, sorter)
+	cfg.Separator = o.Separator
+	cfg.KeyFields = o.KeyFields
+
+	if o.KeyComparatorOptions != "" {
+		sep := o.KeyFieldSeparator
+		if sep == 0 {
+			sep = '\t'
+		}
+		compare, err := sorter.ParseKeyComparatorOptions(o.KeyComparatorOptions, sep)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Compare = compare
+	}
+	return cfg, nil
 }
 
-// TestRawJob simulates a byte mapreduce job by reading the data from the input reader and writing results to the output writer
-func TestByteJob(input []io.Reader, output io.Writer, mapper func(*job.ByteKVWriter, io.Reader), reducer func(io.Writer, *job.ByteKVReader)) {
-	sorter := &testSorter{}
+// Partition holds the output of a single reducer.
+type Partition struct {
+	// Name of the output file, e.g. part-00000.
+	Name   string
+	Output []byte
+	// Path of the output file when Options.OutputDir is set. Output is empty in that case.
+	Path string
+}
 
-	for _, in := range input {
-		setReaderEnv(in)
-		w := job.NewByteKVWriter(sorter)
-		mapper(w, in)
-		w.Flush()
+// Result holds the results of a simulated mapreduce run.
+type Result struct {
+	Partitions []*Partition
+
+	// Counters reported by the job.
+	Counters Counters
+	// App log lines written with job.Log, without the log prefix.
+	Logs []string
+
+	// Number of lines read and written by mappers, combiners and reducers.
+	MapRecords     Records
+	CombineRecords Records
+	ReduceRecords  Records
+
+	// Task attempts of runs with injected failures or speculative attempts.
+	Attempts []*Attempt
+}
+
+// Output returns concatenated output of all the partitions kept in memory.
+func (r *Result) Output() []byte {
+	out := []byte{}
+	for _, p := range r.Partitions {
+		out = append(out, p.Output...)
 	}
-	sorter.sort()
-	reducer(output, job.NewByteKVReader(sorter))
+	return out
 }
 
-// TestRawJob simulates a json mapreduce job by reading the data from the input reader and writing results to the output writer
-func TestJsonJob(input []io.Reader, output io.Writer, mapper func(*job.JsonKVWriter, io.Reader), reducer func(io.Writer, *job.JsonKVReader)) {
-	sorter := &testSorter{}
+// HashPartitioner partitions keys the same way as hadoop's default HashPartitioner does for streaming jobs.
+func HashPartitioner(key []byte, reducers int) int {
+	var hash int32 = 1
+	for _, b := range key {
+		hash = 31*hash + int32(int8(b))
+	}
+	return int(hash&0x7fffffff) % reducers
+}
+
+// partitionWriter splits mapper output into lines and writes each line to the sorter of its partition.
+type partitionWriter struct {
+	partitioner func([]byte, int) int
+	config      *sorter.Config
+	sorters     []*sorter.Sorter
+
+	partial []byte
+	err     error
+}
 
-	for _, in := range input {
-		setReaderEnv(in)
-		w := job.NewJsonKVWriter(sorter)
-		mapper(w, in)
-		w.Flush()
+func (w *partitionWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, p...)
+			break
+		}
+
+		line := p[:i+1]
+		if len(w.partial) > 0 {
+			line = append(w.partial, line...)
+			w.partial = nil
+		}
+		p = p[i+1:]
+
+		if err := w.writeLine(line); err != nil {
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
+
+func (w *partitionWriter) writeLine(line []byte) error {
+	key := w.config.Key(line[:len(line)-1])
+
+	part := w.partitioner(key, len(w.sorters))
+	if part < 0 || part >= len(w.sorters) {
+		w.err = fmt.Errorf("Invalid partition %d for key %q", part, key)
+		return w.err
+	}
+
+	_, err := w.sorters[part].Write(line)
+	return err
+}
+
+// flush writes an unterminated last line of the mapper output.
+func (w *partitionWriter) flush() error {
+	if len(w.partial) > 0 {
+		line := append(w.partial, '\n')
+		w.partial = nil
+		return w.writeLine(line)
+	}
+	return w.err
+}
+
+func newSorters(n int, cfg *sorter.Config) []*sorter.Sorter {
+	sorters := make([]*sorter.Sorter, n)
+	for i := range sorters {
+		sorters[i] = sorter.New(cfg)
+	}
+	return sorters
+}
+
+func closeSorters(sorters []*sorter.Sorter) {
+	for _, s := range sorters {
+		s.Close()
 	}
-	sorter.sort()
-	reducer(output, job.NewJsonKVReader(sorter))
+}
+
+// Run simulates a mapreduce job. Each input is processed by a separate mapper call, mapper output is partitioned, sorted and combined if the job has a combiner,
+// and each partition is processed by a separate reducer call.
+//
+// Mapper output is shuffled the same way as in hadoop streaming: lines are split into the key and the value at the configured separator,
+// sorted by the key only and values of equal keys keep the order in which they were written.
+//
+// Counters and log lines written by the job are captured in the result instead of being written to stderr.
+// The job config and environment variables of the options are set only for the duration of the run.
+// Since they are process wide, simulated runs are serialized and can be safely started from parallel tests.
+func Run(j *job.Job, input []io.Reader, opts *Options) (*Result, error) {
+	return runInProcess(&jobRunner{j}, input, opts)
+}
+
+// runInProcess runs tasks in process, capturing counters and logs and setting the job environment.
+func runInProcess(tr taskRunner, input []io.Reader, opts *Options) (*Result, error) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+
+	restoreEnv, err := opts.setJobEnv()
+	if err != nil {
+		return nil, err
+	}
+	defer restoreEnv()
+
+	capture := newCaptureWriter()
+	defer captureGlobals(capture)()
+
+	var rr *retryRunner
+	if opts.retries() {
+		rr = &retryRunner{taskRunner: tr, opts: opts, capture: capture}
+		tr = rr
+	}
+
+	res := &Result{}
+	if err := run(tr, input, opts, res, capture); err != nil {
+		return nil, err
+	}
+
+	if rr != nil {
+		res.Attempts = rr.attempts
+	}
+
+	res.Counters = capture.counters
+	res.Logs = capture.logs
+	return res, nil
+}
+
+// taskRunner runs a single mapper, combiner or reducer task with task specific environment variables.
+type taskRunner interface {
+	hasCombiner() bool
+	runTask(stage string, env map[string]string, w io.Writer, r io.Reader) error
+}
+
+// jobRunner runs tasks in process by calling the job functions.
+type jobRunner struct {
+	j *job.Job
+}
+
+func (jr *jobRunner) hasCombiner() bool {
+	return jr.j.HasCombiner()
+}
+
+func (jr *jobRunner) runTask(stage string, env map[string]string, w io.Writer, r io.Reader) error {
+	setTaskEnv(env)
+	return jr.j.Run(stage, w, r)
+}
+
+func run(tr taskRunner, input []io.Reader, opts *Options, res *Result, capture *captureWriter) error {
+	cfg, err := opts.sorterConfig()
+	if err != nil {
+		return err
+	}
+
+	if opts != nil && opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	shuffle := newSorters(opts.reducers(), cfg)
+	defer closeSorters(shuffle)
+
+	tasks, err := opts.mapTasks(input)
+	if err != nil {
+		return err
+	}
+
+	capture.setStage("map")
+	for i, task := range tasks {
+		if err := runMapper(tr, task.input, task.env(i, 0), opts, cfg, shuffle, res); err != nil {
+			return err
+		}
+	}
+
+	capture.setStage("reduce")
+	for i, s := range shuffle {
+		p, err := runReducer(tr, i, s, opts, res)
+		if err != nil {
+			return err
+		}
+		res.Partitions = append(res.Partitions, p)
+	}
+
+	return nil
+}
+
+func runReducer(tr taskRunner, partition int, s *sorter.Sorter, opts *Options, res *Result) (*Partition, error) {
+	sorted, err := s.Sort()
+	if err != nil {
+		return nil, err
+	}
+	in := &countingReader{r: sorted, records: &res.ReduceRecords.In}
+	env := taskEnv(false, partition, 0)
+
+	name := fmt.Sprintf("part-%05d", partition)
+	p := &Partition{Name: name}
+
+	if opts == nil || opts.OutputDir == "" {
+		out := &bytes.Buffer{}
+		if err := tr.runTask("reducer", env, &countingWriter{w: out, records: &res.ReduceRecords.Out}, in); err != nil {
+			return nil, err
+		}
+		p.Output = out.Bytes()
+		return p, nil
+	}
+
+	p.Path = filepath.Join(opts.OutputDir, name)
+	f, err := os.Create(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := tr.runTask("reducer", env, &countingWriter{w: w, records: &res.ReduceRecords.Out}, in); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return p, f.Close()
+}
+
+func runMapper(tr taskRunner, in io.Reader, env map[string]string, opts *Options, cfg *sorter.Config, shuffle []*sorter.Sorter, res *Result) error {
+	sorters := shuffle
+	if tr.hasCombiner() {
+		sorters = newSorters(len(shuffle), cfg)
+		defer closeSorters(sorters)
+	}
+
+	pw := &partitionWriter{
+		partitioner: opts.partitioner(),
+		config:      cfg,
+		sorters:     sorters,
+	}
+	err := tr.runTask("mapper", env, &countingWriter{w: pw, records: &res.MapRecords.Out}, &countingReader{r: in, records: &res.MapRecords.In})
+	if err != nil {
+		return err
+	}
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	if !tr.hasCombiner() {
+		return nil
+	}
+
+	for i, s := range sorters {
+		sorted, err := s.Sort()
+		if err != nil {
+			return err
+		}
+		err = tr.runTask("combiner", env, &countingWriter{w: shuffle[i], records: &res.CombineRecords.Out}, &countingReader{r: sorted, records: &res.CombineRecords.In})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeOutput(output io.Writer, res *Result, err error) {
+	if err != nil {
+		panic(err)
+	}
+	output.Write(res.Output())
+}
+
+// RunRawJob simulates a raw mapreduce job and returns its output, counters, logs and record counts.
+func RunRawJob(input []io.Reader, mapper func(io.Writer, io.Reader), reducer func(io.Writer, io.Reader)) (*Result, error) {
+	return Run(job.NewRawJob(mapper, reducer), input, nil)
+}
+
+// RunByteJob simulates a byte mapreduce job and returns its output, counters, logs and record counts.
+func RunByteJob(input []io.Reader, mapper func(*job.ByteKVWriter, io.Reader), reducer func(io.Writer, *job.ByteKVReader)) (*Result, error) {
+	return Run(job.NewByteJob(mapper, reducer), input, nil)
+}
+
+// RunJsonJob simulates a json mapreduce job and returns its output, counters, logs and record counts.
+func RunJsonJob(input []io.Reader, mapper func(*job.JsonKVWriter, io.Reader), reducer func(io.Writer, *job.JsonKVReader)) (*Result, error) {
+	return Run(job.NewJsonJob(mapper, reducer), input, nil)
+}
+
+// TestRawJob simulates a raw mapreduce job by reading the data from the input reader and writing results to the output writer
+func TestRawJob(input []io.Reader, output io.Writer, mapper func(io.Writer, io.Reader), reducer func(io.Writer, io.Reader)) {
+	res, err := Run(job.NewRawJob(mapper, reducer), input, nil)
+	writeOutput(output, res, err)
+}
+
+// TestByteJob simulates a byte mapreduce job by reading the data from the input reader and writing results to the output writer
+func TestByteJob(input []io.Reader, output io.Writer, mapper func(*job.ByteKVWriter, io.Reader), reducer func(io.Writer, *job.ByteKVReader)) {
+	res, err := Run(job.NewByteJob(mapper, reducer), input, nil)
+	writeOutput(output, res, err)
+}
+
+// TestJsonJob simulates a json mapreduce job by reading the data from the input reader and writing results to the output writer
+func TestJsonJob(input []io.Reader, output io.Writer, mapper func(*job.JsonKVWriter, io.Reader), reducer func(io.Writer, *job.JsonKVReader)) {
+	res, err := Run(job.NewJsonJob(mapper, reducer), input, nil)
+	writeOutput(output, res, err)
 }
@@ -3,11 +3,21 @@ package tester
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/Zemanta/mrgob/job"
+	"github.com/Zemanta/mrgob/job/internal/hooks"
 )
 
 func TestRawTester(t *testing.T) {
@@ -35,7 +45,8 @@ word2	1
 		lp := ""
 		c := 0
 		for scanner.Scan() {
-			line := scanner.Text()
+			// hadoop streaming appends a tab to lines without a value
+			line := strings.TrimSuffix(scanner.Text(), "\t")
 			if lp == "" {
 				lp = line
 			}
@@ -140,3 +151,1174 @@ word2	1
 		t.Errorf("\n%s\n!=\n%s", out.String(), expected)
 	}
 }
+
+func TestPartitions(t *testing.T) {
+	in := strings.NewReader("a b c d e f g\na c e\n")
+
+	mapper := func(w *job.ByteKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.Write([]byte(word), []byte("1"))
+			}
+		}
+	}
+	reducer := func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			fmt.Fprintf(w, "%s\t%d\n", key, c)
+		}
+	}
+
+	vowels := func(key []byte, reducers int) int {
+		if strings.ContainsAny(string(key), "aeiou") {
+			return 0
+		}
+		return 1
+	}
+
+	res, err := Run(job.NewByteJob(mapper, reducer), []io.Reader{in}, &Options{Reducers: 2, Partitioner: vowels})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a\t2\ne\t2\n", "b\t1\nc\t2\nd\t1\nf\t1\ng\t1\n"}
+	if len(res.Partitions) != len(expected) {
+		t.Fatalf("Invalid number of partitions: %d", len(res.Partitions))
+	}
+	for i, p := range res.Partitions {
+		if p.Name != fmt.Sprintf("part-0000%d", i) {
+			t.Errorf("Invalid partition name: %s", p.Name)
+		}
+		if string(p.Output) != expected[i] {
+			t.Errorf("Invalid partition %d:\n%s\n!=\n%s", i, p.Output, expected[i])
+		}
+	}
+}
+
+func TestHashPartitioner(t *testing.T) {
+	// values of (new Text(key).hashCode() & Integer.MAX_VALUE) % 7
+	tests := map[string]int{
+		"":              1,
+		"a":             2,
+		"word":          3,
+		"\xc4\x8drka":   3,
+		"longer key 12": 2,
+	}
+	for key, part := range tests {
+		if p := HashPartitioner([]byte(key), 7); p != part {
+			t.Errorf("Invalid partition for %q: %d != %d", key, p, part)
+		}
+	}
+}
+
+func runCat(t *testing.T, in string, opts *Options) string {
+	cat := func(w io.Writer, r io.Reader) {
+		io.Copy(w, r)
+	}
+	res, err := Run(job.NewRawJob(cat, cat), []io.Reader{strings.NewReader(in)}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(res.Output())
+}
+
+func TestShuffle(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		opts     *Options
+		expected string
+	}{
+		{
+			name:     "values of equal keys keep their order",
+			in:       "k\tb\nj\tz\nk\ta\nk\tc\n",
+			expected: "j\tz\nk\tb\nk\ta\nk\tc\n",
+		},
+		{
+			name:     "whitespace isn't trimmed",
+			in:       "b\tvalue \n a\t1\n\n",
+			expected: "\t\n a\t1\nb\tvalue \n",
+		},
+		{
+			name:     "lines without a value end with a tab",
+			in:       "b\na\t1\n",
+			expected: "a\t1\nb\t\n",
+		},
+		{
+			name:     "lines are sorted by the key only",
+			in:       "a\x01\t1\na\t2\n",
+			expected: "a\t2\na\x01\t1\n",
+		},
+		{
+			name:     "lines without a new line at the end",
+			in:       "b\t1\na\t2",
+			expected: "a\t2\nb\t1\n",
+		},
+		{
+			name:     "key fields and separator",
+			in:       "2016.02.b\n2016.01.a.x\n2016.01\n",
+			opts:     &Options{Separator: '.', KeyFields: 2},
+			expected: "2016.01\ta.x\n2016.01\t\n2016.02\tb\n",
+		},
+		{
+			name:     "key comparator options",
+			in:       "a\t1\tx\nb\t2\ty\na\t10\tz\n",
+			opts:     &Options{KeyFields: 2, KeyComparatorOptions: "-k1,1 -k2,2nr"},
+			expected: "a\t10\tz\na\t1\tx\nb\t2\ty\n",
+		},
+	}
+
+	for _, test := range tests {
+		if out := runCat(t, test.in, test.opts); out != test.expected {
+			t.Errorf("%s:\n%q\n!=\n%q", test.name, out, test.expected)
+		}
+	}
+}
+
+func TestSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "tmp")
+	os.Mkdir(tmp, 0755)
+
+	input := func() []io.Reader {
+		in := &bytes.Buffer{}
+		for i := 0; i < 2000; i++ {
+			fmt.Fprintf(in, "word%d word%d\n", i%37, i%11)
+		}
+		return []io.Reader{in}
+	}
+
+	mapper := func(w *job.ByteKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.WriteKey([]byte(word))
+			}
+		}
+	}
+	reducer := func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			fmt.Fprintf(w, "%s\t%d\n", key, c)
+		}
+	}
+	j := job.NewByteJob(mapper, reducer)
+
+	expected, err := Run(j, input(), &Options{Reducers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	res, err := Run(j, input(), &Options{Reducers: 3, SortMemory: 1024, TempDir: tmp, OutputDir: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, p := range res.Partitions {
+		if p.Path != filepath.Join(out, p.Name) {
+			t.Errorf("Invalid partition path: %s", p.Path)
+		}
+		data, err := ioutil.ReadFile(p.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(expected.Partitions[i].Output) {
+			t.Errorf("Spilled partition %s doesn't match:\n%s\n!=\n%s", p.Name, data, expected.Partitions[i].Output)
+		}
+	}
+
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Errorf("Temporary sort files not removed: %d", len(files))
+	}
+}
+
+func TestCapture(t *testing.T) {
+	mapper := func(w *job.ByteKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			job.Count("lines", 1)
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.Write([]byte(word), []byte("1"))
+			}
+		}
+	}
+	reducer := func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			job.Count("keys", 1)
+			fmt.Fprintf(w, "%s\t%d\n", key, c)
+		}
+		job.Log.Print("reducer done")
+	}
+	combiner := func(w *job.ByteKVWriter, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			job.Count("combined", c)
+			w.Write(key, []byte(fmt.Sprint(c)))
+		}
+	}
+
+	input := []io.Reader{strings.NewReader("a b a\nc a\n"), strings.NewReader("b\n")}
+	j := job.NewByteJob(mapper, reducer).WithByteCombiner(combiner)
+
+	res, err := Run(j, input, &Options{Reducers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hooks.CounterWriter != nil {
+		t.Errorf("Counter writer not restored")
+	}
+
+	app := res.Counters.AppCounters()
+	expected := map[string]Counter{
+		"lines":    {Map: 3, Total: 3},
+		"combined": {Map: 6, Total: 6},
+		"keys":     {Reduce: 3, Total: 3},
+	}
+	for name, c := range expected {
+		if app[name] != c {
+			t.Errorf("Invalid counter %s: %+v != %+v", name, app[name], c)
+		}
+	}
+
+	if len(res.Logs) != 2 || !strings.HasSuffix(res.Logs[0], "reducer done") {
+		t.Errorf("Invalid logs: %q", res.Logs)
+	}
+
+	if res.MapRecords != (Records{In: 3, Out: 6}) {
+		t.Errorf("Invalid map records: %+v", res.MapRecords)
+	}
+	if res.CombineRecords != (Records{In: 6, Out: 4}) {
+		t.Errorf("Invalid combine records: %+v", res.CombineRecords)
+	}
+	if res.ReduceRecords != (Records{In: 4, Out: 3}) {
+		t.Errorf("Invalid reduce records: %+v", res.ReduceRecords)
+	}
+}
+
+func TestParseCounter(t *testing.T) {
+	group, name, value, ok := parseCounter("reporter:counter:GOMR,some name,12")
+	if !ok || group != "GOMR" || name != "some name" || value != 12 {
+		t.Errorf("Invalid counter: %s %s %d %v", group, name, value, ok)
+	}
+
+	for _, line := range []string{"reporter:status:x", "reporter:counter:a,b", "reporter:counter:a,b,c", "[GOMR] log"} {
+		if _, _, _, ok := parseCounter(line); ok {
+			t.Errorf("Expected invalid counter: %s", line)
+		}
+	}
+}
+
+func TestJobConfigAndEnv(t *testing.T) {
+	type config struct {
+		Prefix string
+	}
+
+	mapper := func(w *job.ByteKVWriter, r io.Reader) {
+		cfg := &config{}
+		if err := job.Config(cfg); err != nil {
+			job.Log.Print(err)
+		}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			w.WriteKey([]byte(cfg.Prefix + os.Getenv("TESTER_SUFFIX") + scanner.Text()))
+		}
+	}
+	reducer := func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			for vr.Scan() {
+			}
+			fmt.Fprintf(w, "%s\n", key)
+		}
+	}
+
+	os.Setenv("TESTER_SUFFIX", "outer")
+	defer os.Unsetenv("TESTER_SUFFIX")
+
+	res, err := Run(job.NewByteJob(mapper, reducer), []io.Reader{strings.NewReader("a\n")}, &Options{
+		JobConfig: &config{Prefix: "p-"},
+		Env:       map[string]string{"TESTER_SUFFIX": "s-"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Output()) != "p-s-a\n" {
+		t.Errorf("Invalid output: %q", res.Output())
+	}
+
+	if os.Getenv("TESTER_SUFFIX") != "outer" {
+		t.Errorf("Environment not restored: %s", os.Getenv("TESTER_SUFFIX"))
+	}
+	if _, ok := os.LookupEnv(job.ConfigEnv); ok {
+		t.Errorf("Job config not removed")
+	}
+
+	res, err = Run(job.NewByteJob(mapper, reducer), []io.Reader{strings.NewReader("a\n")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Logs) != 1 || !strings.HasSuffix(res.Logs[0], job.ErrMissingJobConfig.Error()) {
+		t.Errorf("Expected missing config, got %q", res.Logs)
+	}
+}
+
+func buildTestBinary(t *testing.T, dir string) string {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	bin := filepath.Join(dir, "wordcount")
+	out, err := exec.Command(gobin, "build", "-o", bin, "./testdata/wordcount").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Building the test binary failed: %s\n%s", err, out)
+	}
+	return bin
+}
+
+func TestRunBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin := buildTestBinary(t, dir)
+
+	input := func() []io.Reader {
+		return []io.Reader{
+			&Reader{Filename: "first", Data: strings.NewReader("a b a\nc\n")},
+			&Reader{Filename: "second", Data: strings.NewReader("b a\n")},
+		}
+	}
+
+	res, err := RunBinary(bin, input(), &Options{JobName: "wordcount", Combiner: true, Reducers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(res.Output())), "\n")
+	sort.Strings(lines)
+	if strings.Join(lines, ",") != "a\t3,b\t2,c\t1" {
+		t.Errorf("Invalid output: %q", res.Output())
+	}
+
+	if c := res.Counters.AppCounters()["lines"]; c.Map != 3 || c.Reduce != 0 {
+		t.Errorf("Invalid counter: %+v", c)
+	}
+	if len(res.Logs) != 2 || !strings.HasSuffix(res.Logs[0], "done") {
+		t.Errorf("Invalid logs: %q", res.Logs)
+	}
+
+	stages := []string{}
+	for _, task := range res.Tasks {
+		stages = append(stages, fmt.Sprintf("%s%d:%d", task.Stage, task.Index, task.ExitCode))
+	}
+	expected := "mapper0:0,combiner0:0,combiner1:0,mapper1:0,combiner2:0,combiner3:0,reducer0:0,reducer1:0"
+	if strings.Join(stages, ",") != expected {
+		t.Errorf("Invalid tasks: %s != %s", strings.Join(stages, ","), expected)
+	}
+
+	res, err = RunBinary(bin, input(), &Options{JobName: "fail"})
+	if err != ErrTaskFailed {
+		t.Fatalf("Expected failed task, got %v", err)
+	}
+	if len(res.Tasks) != 1 || res.Tasks[0].ExitCode != 1 || !strings.Contains(string(res.Tasks[0].Stderr), "failed reading first") {
+		t.Errorf("Invalid failed task: %+v", res.Tasks)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		expected, actual string
+		diff             string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\nc\n", "a\nc\n", "-2: b"},
+		{"a\nc\n", "a\nb\nc\nd\n", "+2: b\n+4: d"},
+		{"a\nb\n", "a\nx\n", "-2: b\n+2: x"},
+	}
+
+	for _, test := range tests {
+		if diff := DiffLines([]byte(test.expected), []byte(test.actual)); diff != test.diff {
+			t.Errorf("Invalid diff of %q and %q:\n%s\n!=\n%s", test.expected, test.actual, diff, test.diff)
+		}
+	}
+}
+
+func TestCheckDeterminism(t *testing.T) {
+	input := func() []io.Reader {
+		return []io.Reader{
+			strings.NewReader("a b a\nc\n"),
+			strings.NewReader("b a\nd\n"),
+			strings.NewReader("c a\n"),
+		}
+	}
+
+	mapper := func(w *job.ByteKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for i := 0; scanner.Scan(); i++ {
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.Write([]byte(word), []byte(fmt.Sprint(i)))
+			}
+		}
+	}
+	count := func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			fmt.Fprintf(w, "%s\t%d\n", key, c)
+		}
+	}
+	// depends on the order of values
+	first := func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			vr.Scan()
+			fmt.Fprintf(w, "%s\t%s\n", key, vr.Value())
+			for vr.Scan() {
+			}
+		}
+	}
+
+	if err := CheckDeterminism(job.NewByteJob(mapper, count), input(), nil, 5); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	err := CheckDeterminism(job.NewByteJob(mapper, first), input(), nil, 5)
+	if _, ok := err.(*CheckError); !ok {
+		t.Fatalf("Expected check error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "\n-") || !strings.Contains(err.Error(), "\n+") {
+		t.Errorf("Diff doesn't contain the differing line:\n%s", err)
+	}
+}
+
+func TestCheckCombiner(t *testing.T) {
+	input := func() []io.Reader {
+		return []io.Reader{
+			strings.NewReader("a b a\nc a b\n"),
+			strings.NewReader("b a\na a\n"),
+		}
+	}
+
+	mapper := func(w *job.JsonKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.Write(word, 1)
+			}
+		}
+	}
+	reducer := func(w io.Writer, r *job.JsonKVReader) {
+		for r.Scan() {
+			var key string
+			vr, _ := r.Key(&key)
+			s := 0
+			for vr.Scan() {
+				var v int
+				vr.Value(&v)
+				s += v
+			}
+			fmt.Fprintf(w, "%s\t%d\n", key, s)
+		}
+	}
+	sum := func(w *job.JsonKVWriter, r *job.JsonKVReader) {
+		for r.Scan() {
+			var key string
+			vr, _ := r.Key(&key)
+			s := 0
+			for vr.Scan() {
+				var v int
+				vr.Value(&v)
+				s += v
+			}
+			w.Write(key, s)
+		}
+	}
+	// counts the values instead of summing them, only correct when applied once
+	count := func(w *job.JsonKVWriter, r *job.JsonKVReader) {
+		for r.Scan() {
+			var key string
+			vr, _ := r.Key(&key)
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			w.Write(key, c)
+		}
+	}
+
+	if err := CheckCombiner(job.NewJsonJob(mapper, reducer), input(), nil, 5); err != ErrMissingCombiner {
+		t.Errorf("Expected missing combiner, got %v", err)
+	}
+
+	if err := CheckCombiner(job.NewJsonJob(mapper, reducer).WithJsonCombiner(sum), input(), &Options{Reducers: 2}, 10); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	err := CheckCombiner(job.NewJsonJob(mapper, reducer).WithJsonCombiner(count), input(), nil, 10)
+	if _, ok := err.(*CheckError); !ok {
+		t.Fatalf("Expected check error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "-1: a\t6") {
+		t.Errorf("Diff doesn't contain the expected line:\n%s", err)
+	}
+
+	byteMapper := func(w *job.ByteKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.Write([]byte(word), []byte("1"))
+			}
+		}
+	}
+	byteReducer := func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			s := 0
+			for vr.Scan() {
+				v, _ := strconv.Atoi(string(vr.Value()))
+				s += v
+			}
+			fmt.Fprintf(w, "%s\t%d\n", key, s)
+		}
+	}
+	byteSum := func(w *job.ByteKVWriter, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			s := 0
+			for vr.Scan() {
+				v, _ := strconv.Atoi(string(vr.Value()))
+				s += v
+			}
+			w.Write(key, []byte(strconv.Itoa(s)))
+		}
+	}
+	byteCount := func(w *job.ByteKVWriter, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			w.Write(key, []byte(strconv.Itoa(c)))
+		}
+	}
+
+	if err := CheckCombiner(job.NewByteJob(byteMapper, byteReducer).WithByteCombiner(byteSum), input(), &Options{Reducers: 2}, 10); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	err = CheckCombiner(job.NewByteJob(byteMapper, byteReducer).WithByteCombiner(byteCount), input(), nil, 10)
+	if _, ok := err.(*CheckError); !ok {
+		t.Fatalf("Expected check error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "-1: a\t6") {
+		t.Errorf("Diff doesn't contain the expected line:\n%s", err)
+	}
+}
+
+func init() {
+	flag.BoolVar(&UpdateGolden, "update", false, "update golden files")
+}
+
+func TestGolden(t *testing.T) {
+	type count struct {
+		Word  string `json:"word"`
+		Count int    `json:"count"`
+		File  string `json:"file"`
+	}
+
+	mapper := func(w *job.JsonKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.Write(word, filepath.Base(os.Getenv(job.InputFileEnv)))
+			}
+		}
+	}
+	reducer := func(w io.Writer, r *job.JsonKVReader) {
+		for r.Scan() {
+			c := &count{}
+			vr, _ := r.Key(&c.Word)
+			for vr.Scan() {
+				vr.Value(&c.File)
+				c.Count++
+			}
+			b, _ := json.Marshal(c)
+			fmt.Fprintf(w, "%s\n", b)
+		}
+	}
+	j := job.NewJsonJob(mapper, reducer)
+
+	err := CheckGolden(j, []string{"golden/input-*.txt"}, "golden/wordcount.golden", &Options{Reducers: 3}, SortedLines, CanonicalJSON)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the rest of the checks compare outputs which don't match the golden files
+	defer func(update bool) { UpdateGolden = update }(UpdateGolden)
+	UpdateGolden = false
+
+	err = CheckGolden(j, []string{"golden/input-1.txt"}, "golden/wordcount.golden", nil, SortedLines, CanonicalJSON)
+	if err == nil || !strings.Contains(err.Error(), "+2: {\"count\":1,\"file\":\"input-1.txt\",\"word\":\"fox\"}") {
+		t.Errorf("Expected golden diff, got %v", err)
+	}
+
+	if err := CheckGolden(j, []string{"golden/missing-*.txt"}, "golden/wordcount.golden", nil); err == nil {
+		t.Errorf("Expected missing fixture error")
+	}
+
+	dir, err := ioutil.TempDir("", "golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	golden := filepath.Join(dir, "new", "out.golden")
+	if err := CompareGolden([]byte("b\na\n"), golden, SortedLines); err == nil {
+		t.Errorf("Expected missing golden file error")
+	}
+
+	UpdateGolden = true
+	err = CompareGolden([]byte("b\na\n"), golden, SortedLines)
+	UpdateGolden = false
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(golden); string(b) != "a\nb\n" {
+		t.Errorf("Invalid updated golden file: %q", b)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	out := CanonicalJSON([]byte("{\"b\": 1, \"a\": [1, 2]}\tplain text\t\"x\"\n"))
+	if string(out) != "{\"a\":[1,2],\"b\":1}\tplain text\t\"x\"\n" {
+		t.Errorf("Invalid canonical json: %q", out)
+	}
+
+	// ids above 2^53 differing only in the last digit
+	out = CanonicalJSON([]byte("{\"id\": 9007199254740993}\n{\"id\": 9007199254740992}\n"))
+	if string(out) != "{\"id\":9007199254740993}\n{\"id\":9007199254740992}\n" {
+		t.Errorf("Invalid canonical json of large numbers: %q", out)
+	}
+}
+
+func fuzzTestJob() *job.Job {
+	mapper := func(w *job.ByteKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 0 && fields[0] == "boom" {
+				panic("boom")
+			}
+			for _, word := range fields {
+				w.Write([]byte(word), []byte("1"))
+			}
+		}
+	}
+	reducer := func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			fmt.Fprintf(w, "%s\t%d\n", key, c)
+		}
+	}
+	return job.NewByteJob(mapper, reducer)
+}
+
+func TestFuzz(t *testing.T) {
+	positive := EachOutputLine(func(line []byte) error {
+		if strings.HasSuffix(string(line), "\t0") {
+			return fmt.Errorf("zero count")
+		}
+		return nil
+	})
+
+	if err := Fuzz(fuzzTestJob(), []byte("a b\nb\n"), nil, positive); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	err := Fuzz(fuzzTestJob(), []byte("a\nboom\n"), nil, positive)
+	if perr, ok := err.(*PanicError); !ok || perr.Stage != "mapper" || perr.Value != "boom" {
+		t.Errorf("Expected mapper panic, got %v", err)
+	}
+
+	// the tester's globals are released after a panic
+	res, err := Run(fuzzTestJob(), []io.Reader{strings.NewReader("a\n")}, nil)
+	if err != nil || string(res.Output()) != "a\t1\n" {
+		t.Errorf("Invalid run after panic: %v %q", err, res.Output())
+	}
+
+	lines := func(input []byte, res *Result) error {
+		if len(input) > 0 && len(res.Output()) == 0 {
+			return fmt.Errorf("no output")
+		}
+		return nil
+	}
+	if err := Fuzz(fuzzTestJob(), []byte(" \n"), nil, lines); err == nil || err.Error() != "no output" {
+		t.Errorf("Expected invariant error, got %v", err)
+	}
+}
+
+func FuzzJob(f *testing.F) {
+	f.Add([]byte("a b a\n"))
+	f.Add([]byte("tab\tseparated words\n"))
+
+	count := func(input []byte, res *Result) error {
+		if res.MapRecords.Out != len(strings.Fields(string(input))) {
+			return fmt.Errorf("%d words != %d mapped", len(strings.Fields(string(input))), res.MapRecords.Out)
+		}
+		return nil
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		err := Fuzz(fuzzTestJob(), input, &Options{Reducers: 2}, count)
+		if perr, ok := err.(*PanicError); ok && perr.Value == "boom" {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestSplitInput(t *testing.T) {
+	data := []byte("first line\nsecond\n\nthird line is longer\nx\nlast")
+
+	tests := []struct {
+		size   int
+		splits []string
+	}{
+		{100, []string{string(data)}},
+		// the line starting at the split boundary belongs to the earlier split
+		{11, []string{"first line\nsecond\n", "\nthird line is longer\n", "", "x\nlast", ""}},
+		{40, []string{"first line\nsecond\n\nthird line is longer\nx\n", "last"}},
+		// the last split can be up to 10% larger
+		{42, []string{string(data)}},
+	}
+
+	for _, test := range tests {
+		splits := []string{}
+		for _, task := range splitInput("f", data, test.size) {
+			b, _ := ioutil.ReadAll(task.input)
+			splits = append(splits, string(b))
+		}
+		if fmt.Sprintf("%q", splits) != fmt.Sprintf("%q", test.splits) {
+			t.Errorf("Invalid splits of size %d: %q != %q", test.size, splits, test.splits)
+		}
+	}
+
+	// every line is read exactly once regardless of the split size
+	for size := 1; size < len(data)+2; size++ {
+		all := ""
+		for _, task := range splitInput("f", data, size) {
+			b, _ := ioutil.ReadAll(task.input)
+			all += string(b)
+		}
+		if all != string(data) {
+			t.Errorf("Splits of size %d don't cover the input: %q", size, all)
+		}
+	}
+
+	if tasks := splitInput("f", nil, 10); len(tasks) != 1 || tasks[0].length != 0 {
+		t.Errorf("Empty input should have a single empty split")
+	}
+}
+
+func TestSplits(t *testing.T) {
+	mapper := func(w io.Writer, r io.Reader) {
+		lines := 0
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines++
+		}
+		fmt.Fprintf(w, "%s\t%s %s %s %s %d\n", os.Getenv(job.TaskAttemptEnv), os.Getenv(job.InputFileEnv), os.Getenv(job.InputStartEnv),
+			os.Getenv(job.InputLengthEnv), os.Getenv(job.TaskIsMapEnv), lines)
+	}
+	reducer := func(w io.Writer, r io.Reader) {
+		// reducers don't see the input split of the last mapper
+		if os.Getenv(job.InputFileEnv) != "" || os.Getenv(job.InputStartEnv) != "" || os.Getenv(job.InputLengthEnv) != "" {
+			t.Errorf("Input split in the reducer environment: %s %s %s", os.Getenv(job.InputFileEnv), os.Getenv(job.InputStartEnv),
+				os.Getenv(job.InputLengthEnv))
+		}
+		io.Copy(w, r)
+		fmt.Fprintf(w, "reducer %s %s\n", os.Getenv(job.TaskPartitionEnv), os.Getenv(job.TaskIsMapEnv))
+	}
+
+	input := []io.Reader{
+		&Reader{Filename: "a", Data: strings.NewReader("line 1\nline 2\nline 3\n")},
+		&Reader{Filename: "b", Data: strings.NewReader("line 4\n")},
+	}
+	res, err := Run(job.NewRawJob(mapper, reducer), input, &Options{SplitSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `attempt_local_0001_m_000000_0	a 0 10 true 2
+attempt_local_0001_m_000001_0	a 10 11 true 1
+attempt_local_0001_m_000002_0	b 0 7 true 1
+reducer 0 false
+`
+	if string(res.Output()) != expected {
+		t.Errorf("Invalid output:\n%s\n!=\n%s", res.Output(), expected)
+	}
+
+	if os.Getenv(job.TaskAttemptEnv) != "" {
+		t.Errorf("Task environment not restored")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	sent := 0
+	mapper := func(w *job.ByteKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			// side effect repeated by every attempt
+			sent++
+			job.Count("lines", 1)
+			w.Write(scanner.Bytes(), []byte("1"))
+		}
+	}
+	reducer := func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			job.Count("keys", 1)
+			fmt.Fprintf(w, "%s\t%d\n", key, c)
+		}
+	}
+	j := job.NewByteJob(mapper, reducer)
+
+	input := func() []io.Reader {
+		return []io.Reader{strings.NewReader("a\nb\na\n"), strings.NewReader("c\n")}
+	}
+
+	opts := &Options{
+		Reducers: 2,
+		Failures: []*Failure{
+			{Stage: "mapper", Task: 0, AfterRecords: 2},
+			{Stage: "reducer", Task: -1, AfterRecords: 1, Panic: true, Attempts: 2},
+		},
+	}
+	res, err := Run(j, input(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(SortedLines(res.Output())) != "a\t2\nb\t1\nc\t1\n" {
+		t.Errorf("Invalid output: %q", res.Output())
+	}
+	if c := res.Counters.AppCounters(); c["lines"].Total != 4 || c["keys"].Total != 3 {
+		t.Errorf("Counters of failed attempts weren't discarded: %+v", c)
+	}
+	if sent != 6 {
+		t.Errorf("Invalid number of mapper side effects: %d != 6", sent)
+	}
+
+	attempts := []string{}
+	for _, a := range res.Attempts {
+		attempts = append(attempts, fmt.Sprintf("%s%d.%d:%v", a.Stage, a.Task, a.Attempt, a.Committed))
+		if !a.Committed && a.Err == nil {
+			t.Errorf("Failed attempt without error: %+v", a)
+		}
+	}
+	expected := "mapper0.0:false,mapper0.1:true,mapper1.0:true,reducer0.0:false,reducer0.1:false,reducer0.2:true,reducer1.0:false,reducer1.1:false,reducer1.2:true"
+	if strings.Join(attempts, ",") != expected {
+		t.Errorf("Invalid attempts:\n%s\n!=\n%s", strings.Join(attempts, ","), expected)
+	}
+	if perr, ok := res.Attempts[3].Err.(*PanicError); !ok || perr.Value != ErrInjectedFailure {
+		t.Errorf("Expected injected panic, got %v", res.Attempts[3].Err)
+	}
+
+	opts.MaxAttempts = 2
+	if _, err := Run(j, input(), opts); err == nil {
+		t.Errorf("Expected failed job after max attempts")
+	}
+
+	sent = 0
+	if err := CheckRetries(j, input(), &Options{Speculative: true, Failures: []*Failure{{Stage: "mapper", Task: -1}}}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	// clean run, retried attempts and speculative duplicates, failed attempts read no records
+	if sent != 4+4+4 {
+		t.Errorf("Invalid number of mapper side effects: %d != 12", sent)
+	}
+
+	attemptMapper := func(w *job.ByteKVWriter, r io.Reader) {
+		io.Copy(ioutil.Discard, r)
+		w.Write([]byte(os.Getenv(job.TaskAttemptEnv)), nil)
+	}
+	err = CheckRetries(job.NewByteJob(attemptMapper, reducer), input(), &Options{Failures: []*Failure{{Stage: "mapper", Task: 1}}})
+	if cerr, ok := err.(*CheckError); !ok || !strings.Contains(cerr.Diff, "attempt_local_0001_m_000001_1") {
+		t.Errorf("Expected attempt dependent output, got %v", err)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	type count struct {
+		Count int
+		Files []string
+	}
+
+	out := &bytes.Buffer{}
+	w := job.NewJsonKVWriter(out)
+	w.Write("b", &count{Count: 2, Files: []string{"x"}})
+	w.Write("a", &count{Count: 1})
+	w.Write("c", &count{Count: 3})
+	w.Flush()
+
+	records, err := Decode(out.Bytes(), JsonDecoder("", &count{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[0].Key != "b" || records[0].Value.(*count).Files[0] != "x" {
+		t.Errorf("Invalid record: %s", records[0])
+	}
+
+	expected := []*Record{
+		{"a", &count{Count: 1}},
+		{"b", &count{Count: 2, Files: []string{"x"}}},
+		{"c", &count{Count: 3}},
+	}
+	if err := MatchRecords(expected, records); err != nil {
+		t.Error(err)
+	}
+	if err := ContainsRecords(expected[1:], records); err != nil {
+		t.Error(err)
+	}
+	if err := MatchKeys([]*Record{{"c", &count{Count: 3}}}, records); err != nil {
+		t.Error(err)
+	}
+
+	err = MatchRecords(expected[:2], records)
+	if err == nil || !strings.Contains(err.Error(), "+3: \"c\"\t{\"Count\":3,\"Files\":null}") {
+		t.Errorf("Expected records diff, got %v", err)
+	}
+	err = ContainsRecords([]*Record{{"a", &count{Count: 1}}, {"a", &count{Count: 1}}}, records)
+	if err == nil || !strings.Contains(err.Error(), "Missing 1 of 2") {
+		t.Errorf("Expected missing record, got %v", err)
+	}
+	err = MatchKeys([]*Record{{"a", &count{Count: 2}}, {"d", &count{}}}, records)
+	if err == nil || !strings.Contains(err.Error(), "Values of 2 keys don't match") || !strings.Contains(err.Error(), "key \"d\"") {
+		t.Errorf("Expected per key diff, got %v", err)
+	}
+
+	records, err = Decode([]byte("{\"a\": 1}\t[1, 2]\n\"k\"\n"), JsonDecoder(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MatchRecords([]*Record{{map[string]interface{}{"a": 1}, []int{1, 2}}, {"k", nil}}, records); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := Decode([]byte("\"a\"\t1\nnot json\t1\n"), JsonDecoder("", 0)); err == nil || !strings.HasPrefix(err.Error(), "Line 2:") {
+		t.Errorf("Expected decode error, got %v", err)
+	}
+}
+
+func TestByteDecoder(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := job.NewByteKVWriter(out)
+	w.Write([]byte("a\tb"), []byte("c\\d\ne"))
+	w.WriteKey([]byte("key"))
+	w.Flush()
+
+	res := &Result{Partitions: []*Partition{{Output: out.Bytes()}}}
+	records, err := res.Records(ByteDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MatchRecords([]*Record{{"key", ""}, {"a\tb", "c\\d\ne"}}, records); err != nil {
+		t.Error(err)
+	}
+	// lines with spare capacity aren't modified
+	buf := []byte("first\tvalue\tsecond")
+	line := buf[:len("first\tvalue")]
+	for _, dec := range []Decoder{ByteDecoder, JsonDecoder(nil, nil)} {
+		dec(line)
+		if string(buf) != "first\tvalue\tsecond" {
+			t.Errorf("Line modified: %q", buf)
+		}
+	}
+}
+
+func TestBenchmarkJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mapper := func(w *job.ByteKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.Write([]byte(word), []byte("1"))
+			}
+		}
+	}
+	reducer := func(w io.Writer, r *job.ByteKVReader) {
+		// the reducer doesn't see the input split of the map phase
+		if os.Getenv(job.InputStartEnv) != "" || os.Getenv(job.InputLengthEnv) != "" {
+			t.Errorf("Input split in the reducer environment: %s %s", os.Getenv(job.InputStartEnv), os.Getenv(job.InputLengthEnv))
+		}
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			fmt.Fprintf(w, "%s\t%d\n", key, c)
+		}
+	}
+	combiner := func(w *job.ByteKVWriter, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			for vr.Scan() {
+				w.Write(key, vr.Value())
+			}
+		}
+	}
+	j := job.NewByteJob(mapper, reducer).WithByteCombiner(combiner)
+
+	input := &bytes.Buffer{}
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(input, "word%d word%d\n", i%37, i%11)
+	}
+
+	res := testing.Benchmark(func(b *testing.B) {
+		BenchmarkJob(b, j, input.Bytes(), nil, dir)
+	})
+
+	for _, phase := range []string{"map", "sort", "combine", "reduce"} {
+		if res.Extra[phase+"-ns/op"] <= 0 || res.Extra[phase+"-records/s"] <= 0 {
+			t.Errorf("Missing metrics of the %s phase: %v", phase, res.Extra)
+		}
+		for _, profile := range []string{".cpu.pprof", ".heap.pprof"} {
+			if _, err := os.Stat(filepath.Join(dir, phase+profile)); err != nil {
+				t.Errorf("Missing profile: %s", err)
+			}
+		}
+	}
+	if res.Extra["map-allocs/record"] <= 0 {
+		t.Errorf("Missing allocations: %v", res.Extra)
+	}
+	if os.Getenv(job.TaskAttemptEnv) != "" || os.Getenv(job.InputStartEnv) != "" {
+		t.Errorf("Task environment not restored")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	// word counts
+	words := job.NewByteJob(func(w *job.ByteKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			for _, word := range strings.Fields(scanner.Text()) {
+				w.Write([]byte(word), []byte("1"))
+			}
+		}
+	}, func(w io.Writer, r *job.ByteKVReader) {
+		for r.Scan() {
+			key, vr := r.Key()
+			c := 0
+			for vr.Scan() {
+				c++
+			}
+			fmt.Fprintf(w, "%s\t%d\n", key, c)
+		}
+	})
+
+	// number of words with each count
+	histogram := job.NewJsonJob(func(w *job.JsonKVWriter, r io.Reader) {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			var word string
+			var count int
+			fmt.Sscanf(scanner.Text(), "%s\t%d", &word, &count)
+			w.Write(count, word)
+		}
+	}, func(w io.Writer, r *job.JsonKVReader) {
+		for r.Scan() {
+			var count int
+			vr, _ := r.Key(&count)
+			n := 0
+			for vr.Scan() {
+				n++
+			}
+			fmt.Fprintf(w, "%d\t%d\n", count, n)
+		}
+	})
+
+	// input files of the mappers
+	files := job.NewRawJob(func(w io.Writer, r io.Reader) {
+		io.Copy(ioutil.Discard, r)
+		fmt.Fprintf(w, "%s\n", os.Getenv(job.InputFileEnv))
+	}, func(w io.Writer, r io.Reader) {
+		io.Copy(w, r)
+	})
+
+	stages := []*Stage{
+		{Name: "words", Job: words, Options: &Options{Reducers: 2}},
+		{Name: "histogram", Job: histogram},
+		{Name: "files", Job: files, Inputs: []string{"words", "histogram"}},
+	}
+	res, err := RunPipeline(stages, []io.Reader{strings.NewReader("a b a\nc a b\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Stages) != 3 || res.Stage("words") != res.Stages[0] || res.Stage("missing") != nil {
+		t.Fatalf("Invalid stages: %v", res.Stages)
+	}
+	if out := string(SortedLines(res.Stage("words").Output())); out != "a\t3\nb\t2\nc\t1\n" {
+		t.Errorf("Invalid words output:\n%s", out)
+	}
+	if out := string(res.Stage("histogram").Output()); out != "1\t1\n2\t1\n3\t1\n" {
+		t.Errorf("Invalid histogram output:\n%s", out)
+	}
+	if res.Stage("histogram").MapRecords.In != 3 {
+		t.Errorf("Invalid histogram input records: %d", res.Stage("histogram").MapRecords.In)
+	}
+	if out := string(res.Output()); out != "histogram/part-00000\t\nwords/part-00000\t\nwords/part-00001\t\n" {
+		t.Errorf("Invalid files output:\n%s", out)
+	}
+
+	_, err = RunPipeline([]*Stage{{Name: "files", Job: files, Inputs: []string{"words"}}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown input stage") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/mrgob/runner/provider"
+	"golang.org/x/crypto/ssh"
+)
+
+// Client runs commands on the hadoop cluster of its provider. Clients of different clusters can be used side by side.
+type Client struct {
+	// Provider of the cluster's master host and ssh client.
+	Provider provider.HadoopProvider
+	// Base urls of the YARN ResourceManager and JobHistory server REST APIs. Defaults to the provider if it implements EndpointResolver,
+	// otherwise to the default ports of the master host. Use an HAEndpointResolver for clusters with multiple ResourceManagers.
+	Endpoints EndpointResolver
+
+	// HTTP client of the REST calls. Defaults to a client using TLSConfig and Timeout.
+	HTTPClient *http.Client
+	// TLS config of https REST calls of the default http client.
+	TLSConfig *tls.Config
+	// Timeout of REST calls of the default http client. Defaults to 30 seconds.
+	Timeout time.Duration
+	// Route REST calls of the default http client through an ssh tunnel to the master, e.g. when the cluster's private network
+	// is only reachable through a bastion. The tunnel is closed by Close.
+	Tunnel bool
+	// Logger of the command output and progress. Defaults to the standard logger.
+	Logger *log.Logger
+
+	// Commands are submitted only while the master load is lower than this. Defaults to 16.
+	MaxMasterLoad int
+	// Interval of application status checks. Defaults to 5 seconds.
+	StatusInterval time.Duration
+	// Wait before retrying a failed command. Defaults to 10 seconds.
+	RetryBackoff time.Duration
+
+	httpMu      sync.Mutex
+	defaultHTTP *http.Client
+	tunnel      *SSHTunnel
+
+	rmMu     sync.Mutex
+	activeRM string
+}
+
+// NewClient returns a client running commands on the cluster of the provider.
+func NewClient(p provider.HadoopProvider) *Client {
+	return &Client{Provider: p}
+}
+
+// defaultClient is used by the package level functions.
+var defaultClient = &Client{}
+
+// SetDefaultHadoopProvider sets the provider of the default client used by the package level functions.
+func SetDefaultHadoopProvider(p provider.HadoopProvider) {
+	defaultClient.Provider = p
+}
+
+// DefaultClient returns the client used by the package level functions.
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+func (c *Client) debugLog(s string, a ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(s, a...)
+		return
+	}
+	log.Printf(s, a...)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+
+	c.httpMu.Lock()
+	defer c.httpMu.Unlock()
+
+	if c.defaultHTTP != nil {
+		return c.defaultHTTP
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = c.TLSConfig
+	if c.Tunnel {
+		c.tunnel = NewSSHTunnel(c.Provider)
+		transport = c.tunnel.Transport(c.TLSConfig)
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = requestTimeout
+	}
+	c.defaultHTTP = &http.Client{Transport: transport, Timeout: timeout}
+	return c.defaultHTTP
+}
+
+// Close closes idle REST connections and the ssh tunnel of the client.
+func (c *Client) Close() error {
+	c.httpMu.Lock()
+	defer c.httpMu.Unlock()
+
+	if c.defaultHTTP == nil {
+		return nil
+	}
+	c.defaultHTTP.CloseIdleConnections()
+	if c.tunnel != nil {
+		return c.tunnel.Close()
+	}
+	return nil
+}
+
+func (c *Client) endpoints() EndpointResolver {
+	if c.Endpoints != nil {
+		return c.Endpoints
+	}
+	if e, ok := c.Provider.(EndpointResolver); ok {
+		return e
+	}
+	return &MasterEndpoints{Provider: c.Provider}
+}
+
+func (c *Client) masterSSHClient() (*ssh.Client, error) {
+	if c.Provider == nil {
+		return nil, ErrMissingHadoopProvider
+	}
+	return c.Provider.GetMasterSSHClient()
+}
+
+func (c *Client) loadCheck() string {
+	max := c.MaxMasterLoad
+	if max <= 0 {
+		max = maxMasterLoad
+	}
+	return fmt.Sprintf(loadCheck, max)
+}
+
+func (c *Client) statusInterval() time.Duration {
+	if c.StatusInterval <= 0 {
+		return waitForStatus
+	}
+	return c.StatusInterval
+}
+
+func (c *Client) retryBackoff() time.Duration {
+	if c.RetryBackoff <= 0 {
+		return retryBackoff
+	}
+	return c.RetryBackoff
+}
+
+// NewRawMapReduce returns a command running the hadoop command with the arguments on the client's cluster.
+func (c *Client) NewRawMapReduce(arguments ...string) *HadoopCommand {
+	return &HadoopCommand{
+		client: c,
+		args:   arguments,
+		done:   make(chan struct{}),
+	}
+}
+
+// NewMapReduce returns a command running the mapreduce job on the client's cluster.
+func (c *Client) NewMapReduce(config *MapReduceConfig) (*HadoopCommand, error) {
+	args, err := config.getArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	hc := c.NewRawMapReduce(args...)
+	hc.description = config.JobDescription
+	return hc, nil
+}
@@ -0,0 +1,144 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type testProvider struct {
+	host string
+}
+
+func (p *testProvider) GetMasterHost() (string, error) {
+	return p.host, nil
+}
+
+func (p *testProvider) GetMasterSSHClient() (*ssh.Client, error) {
+	return nil, ErrMissingHadoopProvider
+}
+
+// roundTripper responds to all the requests with the body and records their urls.
+type roundTripper struct {
+	body string
+	urls []string
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.urls = append(rt.urls, req.URL.String())
+	return &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(rt.body)),
+		Header:     http.Header{},
+		Request:    req,
+	}, nil
+}
+
+// runningCommand returns a command of the client with a submitted application.
+func runningCommand(c *Client, applicationId string) *HadoopCommand {
+	hc := c.NewRawMapReduce("hadoop-streaming")
+	hc.tries = append(hc.tries, &HadoopRun{command: hc, applicationId: applicationId})
+	return hc
+}
+
+func TestClients(t *testing.T) {
+	clients := []*Client{}
+	transports := []*roundTripper{}
+	for _, host := range []string{"master-a", "master-b"} {
+		rt := &roundTripper{body: `{"app": {"finalStatus": "SUCCEEDED"}}`}
+		transports = append(transports, rt)
+		clients = append(clients, &Client{
+			Provider:   &testProvider{host},
+			HTTPClient: &http.Client{Transport: rt},
+			Logger:     log.New(ioutil.Discard, "", 0),
+		})
+	}
+
+	for i, c := range clients {
+		status, err := runningCommand(c, fmt.Sprintf("application_1_%04d", i+1)).FetchApplicationStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.App.FinalStatus != "SUCCEEDED" {
+			t.Errorf("Invalid status: %s", status.App.FinalStatus)
+		}
+	}
+
+	if len(transports[0].urls) != 1 || transports[0].urls[0] != "http://master-a:8088/ws/v1/cluster/apps/application_1_0001" {
+		t.Errorf("Invalid requests of the first client: %v", transports[0].urls)
+	}
+	if len(transports[1].urls) != 1 || transports[1].urls[0] != "http://master-b:8088/ws/v1/cluster/apps/application_1_0002" {
+		t.Errorf("Invalid requests of the second client: %v", transports[1].urls)
+	}
+}
+
+func TestClientLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := &Client{
+		Provider:   &testProvider{"master"},
+		HTTPClient: &http.Client{Transport: &roundTripper{body: `{"app": {"name": "wordcount", "state": "RUNNING"}}`}},
+		Logger:     log.New(buf, "", 0),
+	}
+
+	if _, err := runningCommand(c, "application_1_0001").FetchApplicationStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "wordcount: [state: RUNNING") {
+		t.Errorf("Missing log output: %q", buf.String())
+	}
+
+	buf.Reset()
+	raw := "Container: container_1_0001_01_000002 on worker_8041\nLogType:stderr\nLog Contents:\n[GOMR]mapper started\n"
+	if _, err := newHadoopApplicationLogs(c, raw); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Container: container_1_0001_01_000002 on worker_8041\nmapper started\n" {
+		t.Errorf("Invalid log output: %q", buf.String())
+	}
+}
+
+func TestMissingProvider(t *testing.T) {
+	c := &Client{}
+
+	if err := c.ExecOnCluster(0, "ls"); err != ErrMissingHadoopProvider {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	hc := c.NewRawMapReduce("hadoop-streaming")
+	hc.Run()
+	if _, _, err := hc.CmdOutput(); err != ErrMissingHadoopProvider {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	if _, err := runningCommand(c, "application_1_0001").FetchApplicationStatus(); err != ErrMissingHadoopProvider {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	c := &Client{Provider: &testProvider{"master"}, Tunnel: true}
+
+	// closing doesn't race with the lazy init of the default http client
+	done := make(chan struct{})
+	go func() {
+		c.httpClient()
+		close(done)
+	}()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if c.httpClient() != c.httpClient() {
+		t.Errorf("Default http client not reused")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
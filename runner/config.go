@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"path"
 	"strconv"
+
+	"github.com/Zemanta/mrgob/job"
 )
 
 var (
-	ErrMissingJobPath = fmt.Errorf("Missing job path")
-	ErrMissingInput   = fmt.Errorf("Missing input")
-	ErrMissingOutput  = fmt.Errorf("Missing output")
+	ErrMissingJobPath  = fmt.Errorf("Missing job path")
+	ErrMissingInput    = fmt.Errorf("Missing input")
+	ErrMissingOutput   = fmt.Errorf("Missing output")
+	ErrJobNotFound     = fmt.Errorf("Job not found in the job description")
+	ErrMissingCombiner = fmt.Errorf("Job has no combiner")
 )
 
 type MapReduceConfig struct {
@@ -24,6 +28,13 @@ type MapReduceConfig struct {
 
 	// S3 or HDFS path to the executable job implementing "Init*Job" interface.
 	JobPath string
+	// Name of the job registered with job.Register when the executable contains multiple jobs.
+	JobName string
+	// Run the combiner stage of the job.
+	Combiner bool
+
+	// Metadata of the executable returned by DescribeJob. When set, the config is validated against it before submission.
+	JobDescription *job.Description
 
 	// Job configuration that will be made available in mapper and reducer jobs.
 	JobConfig interface{}
@@ -39,6 +50,9 @@ type MapReduceConfig struct {
 	AdditionalFiles []string
 	// Environment options passed to the mapreduce jobs.
 	Env map[string]string
+
+	// Report throughput and timing counters of each task, available through HadoopJobCounters.StageStats.
+	Stats bool
 }
 
 func (c *MapReduceConfig) getFileArg(fn string) []string {
@@ -75,22 +89,57 @@ func (c *MapReduceConfig) getProperyArg(k string, v interface{}) []string {
 	return nil
 }
 
+func (c *MapReduceConfig) getStageCommand(stage string) string {
+	cmd := fmt.Sprintf("%s -stage=%s", path.Base(c.JobPath), stage)
+	if c.JobName != "" {
+		cmd += fmt.Sprintf(" -job=%s", c.JobName)
+	}
+	return cmd
+}
+
 func (c *MapReduceConfig) getConfigProperty() ([]string, error) {
-	b, err := json.Marshal(c.JobConfig)
+	str, err := job.EncodeConfig(c.JobConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	str := string(b)
 	str = strconv.Quote(str)
 
 	args := []string{
-		"-cmdenv", fmt.Sprintf("mrgob_config=\"%s\"", str),
+		"-cmdenv", fmt.Sprintf("%s=\"%s\"", job.ConfigEnv, str),
 	}
 
 	return args, nil
 }
 
+// Validate checks the job name, combiner and job config against the job description.
+func (c *MapReduceConfig) Validate(d *job.Description) error {
+	jd, err := d.Job(c.JobName)
+	if err != nil {
+		return ErrJobNotFound
+	}
+
+	if c.Combiner && !jd.Combiner {
+		return ErrMissingCombiner
+	}
+
+	if c.JobConfig != nil && jd.Config != nil {
+		b, err := json.Marshal(c.JobConfig)
+		if err != nil {
+			return err
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		if err := jd.Config.Validate(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (c *MapReduceConfig) getArgs() ([]string, error) {
 	// TODO config
 
@@ -103,6 +152,11 @@ func (c *MapReduceConfig) getArgs() ([]string, error) {
 	if c.Output == "" {
 		return nil, ErrMissingOutput
 	}
+	if c.JobDescription != nil {
+		if err := c.Validate(c.JobDescription); err != nil {
+			return nil, err
+		}
+	}
 
 	args := []string{"hadoop-streaming"}
 
@@ -126,9 +180,11 @@ func (c *MapReduceConfig) getArgs() ([]string, error) {
 		args = append(args, c.getFileArg(fn)...)
 	}
 
-	execFile := path.Base(c.JobPath)
-	args = append(args, c.getArg("-mapper", fmt.Sprintf("%s -stage=mapper", execFile))...)
-	args = append(args, c.getArg("-reducer", fmt.Sprintf("%s -stage=reducer", execFile))...)
+	args = append(args, c.getArg("-mapper", c.getStageCommand("mapper"))...)
+	if c.Combiner {
+		args = append(args, c.getArg("-combiner", c.getStageCommand("combiner"))...)
+	}
+	args = append(args, c.getArg("-reducer", c.getStageCommand("reducer"))...)
 
 	for _, f := range c.Input {
 		args = append(args, c.getArg("-input", f)...)
@@ -140,6 +196,10 @@ func (c *MapReduceConfig) getArgs() ([]string, error) {
 		args = append(args, c.getEnvArg(k, v)...)
 	}
 
+	if c.Stats {
+		args = append(args, c.getEnvArg(job.StatsEnv, "true")...)
+	}
+
 	if c.JobConfig != nil {
 		a, err := c.getConfigProperty()
 		if err != nil {
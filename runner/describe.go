@@ -0,0 +1,23 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"github.com/Zemanta/mrgob/job"
+)
+
+// DescribeJob runs a local copy of the job executable with --stage=describe and decodes the printed job description.
+func DescribeJob(path string) (*job.Description, error) {
+	out, err := exec.Command(path, "-stage=describe").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Job describe error: %s", err)
+	}
+
+	d := &job.Description{}
+	if err := json.Unmarshal(out, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/Zemanta/mrgob/runner/provider"
+)
+
+// EndpointResolver returns the base urls of the cluster's REST APIs, e.g. https://master:8090.
+// Providers implementing it are used as the resolver of clients without one.
+type EndpointResolver interface {
+	ResourceManagerURL() (string, error)
+	HistoryServerURL() (string, error)
+}
+
+// MasterEndpoints resolves the REST APIs on the master host of the provider.
+type MasterEndpoints struct {
+	Provider provider.HadoopProvider
+	// Scheme of the urls, http or https. Defaults to http.
+	Scheme string
+	// Port of the YARN ResourceManager REST API. Defaults to 8088.
+	ResourceManagerPort int
+	// Port of the MapReduce JobHistory server REST API. Defaults to 19888.
+	HistoryServerPort int
+}
+
+func (e *MasterEndpoints) url(port, defaultPort int) (string, error) {
+	if e.Provider == nil {
+		return "", ErrMissingHadoopProvider
+	}
+	host, err := e.Provider.GetMasterHost()
+	if err != nil {
+		return "", err
+	}
+
+	scheme := e.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	if port <= 0 {
+		port = defaultPort
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, host, port), nil
+}
+
+func (e *MasterEndpoints) ResourceManagerURL() (string, error) {
+	return e.url(e.ResourceManagerPort, hadoopApiPort)
+}
+
+func (e *MasterEndpoints) HistoryServerURL() (string, error) {
+	return e.url(e.HistoryServerPort, historyServerPort)
+}
+
+// StaticEndpoints are fixed base urls of the REST APIs.
+type StaticEndpoints struct {
+	ResourceManager string
+	HistoryServer   string
+}
+
+func (e *StaticEndpoints) ResourceManagerURL() (string, error) {
+	return e.ResourceManager, nil
+}
+
+func (e *StaticEndpoints) HistoryServerURL() (string, error) {
+	return e.HistoryServer, nil
+}
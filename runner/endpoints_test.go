@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMasterEndpoints(t *testing.T) {
+	e := &MasterEndpoints{Provider: &testProvider{"master"}}
+	rm, _ := e.ResourceManagerURL()
+	hs, _ := e.HistoryServerURL()
+	if rm != "http://master:8088" || hs != "http://master:19888" {
+		t.Errorf("Invalid default endpoints: %s %s", rm, hs)
+	}
+
+	e = &MasterEndpoints{Provider: &testProvider{"master"}, Scheme: "https", ResourceManagerPort: 8090, HistoryServerPort: 19890}
+	rm, _ = e.ResourceManagerURL()
+	hs, _ = e.HistoryServerURL()
+	if rm != "https://master:8090" || hs != "https://master:19890" {
+		t.Errorf("Invalid endpoints: %s %s", rm, hs)
+	}
+
+	if _, err := (&MasterEndpoints{}).ResourceManagerURL(); err != ErrMissingHadoopProvider {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+type endpointsProvider struct {
+	testProvider
+	StaticEndpoints
+}
+
+func TestProviderEndpoints(t *testing.T) {
+	p := &endpointsProvider{testProvider{"master"}, StaticEndpoints{"https://rm:8090", "https://history:19890"}}
+	rm, _ := (&Client{Provider: p}).endpoints().ResourceManagerURL()
+	if rm != "https://rm:8090" {
+		t.Errorf("Provider endpoints not used: %s", rm)
+	}
+
+	rm, _ = (&Client{Provider: p, Endpoints: &StaticEndpoints{ResourceManager: "http://other:8088"}}).endpoints().ResourceManagerURL()
+	if rm != "http://other:8088" {
+		t.Errorf("Client endpoints not used: %s", rm)
+	}
+}
+
+func TestHTTPSEndpoints(t *testing.T) {
+	rm := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws/v1/cluster/apps/application_1_0001" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"app": {"finalStatus": "SUCCEEDED"}}`)
+	}))
+	defer rm.Close()
+
+	history := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws/v1/history/mapreduce/jobs/job_1_0001/counters" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"jobCounters": {"counterGroup": [{"counterGroupName": "Group", "counter": [{"name": "lines", "totalCounterValue": 3}]}]}}`)
+	}))
+	defer history.Close()
+
+	c := &Client{
+		Provider:  &testProvider{"master"},
+		Endpoints: &StaticEndpoints{ResourceManager: rm.URL, HistoryServer: history.URL},
+		TLSConfig: rm.Client().Transport.(*http.Transport).TLSClientConfig,
+		Logger:    log.New(ioutil.Discard, "", 0),
+	}
+	hc := runningCommand(c, "application_1_0001")
+
+	status, err := hc.FetchApplicationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.App.FinalStatus != "SUCCEEDED" {
+		t.Errorf("Invalid status: %s", status.App.FinalStatus)
+	}
+
+	counters, err := hc.FetchJobCounters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counters["Group"]["lines"].TotalCounterValue != 3 {
+		t.Errorf("Invalid counters: %v", counters)
+	}
+
+	if _, err := runningCommand(c, "application_1_0002").FetchApplicationStatus(); err == nil {
+		t.Errorf("Missing error of a not found application")
+	}
+
+	// the certificate of the test server isn't trusted by default
+	c = &Client{
+		Provider:  &testProvider{"master"},
+		Endpoints: &StaticEndpoints{ResourceManager: rm.URL},
+		Logger:    log.New(ioutil.Discard, "", 0),
+	}
+	if _, err := runningCommand(c, "application_1_0001").FetchApplicationStatus(); err == nil {
+		t.Errorf("Missing certificate error")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	c := &Client{
+		Provider:  &testProvider{"master"},
+		Endpoints: &StaticEndpoints{ResourceManager: srv.URL},
+		Timeout:   50 * time.Millisecond,
+		Logger:    log.New(ioutil.Discard, "", 0),
+	}
+	if _, err := runningCommand(c, "application_1_0001").FetchApplicationStatus(); err == nil {
+		t.Errorf("Missing timeout error")
+	}
+}
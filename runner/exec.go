@@ -6,7 +6,13 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+// ExecOnCluster runs the command with the arguments on the master of the default client's cluster.
 func ExecOnCluster(retries int, arguments ...string) error {
+	return defaultClient.ExecOnCluster(retries, arguments...)
+}
+
+// ExecOnCluster runs the command with the arguments on the master of the client's cluster.
+func (c *Client) ExecOnCluster(retries int, arguments ...string) error {
 	var err error
 
 	success := false
@@ -15,7 +21,7 @@ func ExecOnCluster(retries int, arguments ...string) error {
 		var session *ssh.Session
 		var client *ssh.Client
 
-		client, err = hadoopProvider.GetMasterSSHClient()
+		client, err = c.masterSSHClient()
 		if err != nil {
 			continue
 		}
@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrNoActiveResourceManager = fmt.Errorf("No active ResourceManager")
+	ErrMissingResourceManager  = fmt.Errorf("Missing ResourceManager url")
+)
+
+// HAEndpointResolver returns the base urls of all the ResourceManagers of a cluster in high availability mode.
+// Clients of resolvers implementing it send the REST calls to the active ResourceManager and fail over when it becomes standby.
+type HAEndpointResolver interface {
+	EndpointResolver
+	ResourceManagerURLs() ([]string, error)
+}
+
+// HAEndpoints are fixed base urls of a cluster with multiple ResourceManagers.
+type HAEndpoints struct {
+	ResourceManagers []string
+	HistoryServer    string
+}
+
+func (e *HAEndpoints) ResourceManagerURL() (string, error) {
+	if len(e.ResourceManagers) == 0 {
+		return "", ErrMissingResourceManager
+	}
+	return e.ResourceManagers[0], nil
+}
+
+func (e *HAEndpoints) ResourceManagerURLs() ([]string, error) {
+	if len(e.ResourceManagers) == 0 {
+		return nil, ErrMissingResourceManager
+	}
+	return e.ResourceManagers, nil
+}
+
+func (e *HAEndpoints) HistoryServerURL() (string, error) {
+	return e.HistoryServer, nil
+}
+
+type clusterInfo struct {
+	ClusterInfo struct {
+		HAState string `json:"haState"`
+	} `json:"clusterInfo"`
+}
+
+// isActive checks the high availability state of the ResourceManager.
+func (c *Client) isActive(ctx context.Context, rm string) (bool, error) {
+	resp, err := c.do(ctx, "GET", rm+clusterInfoApiPath, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return false, fmt.Errorf("Fetch cluster info error %s", resp.Status)
+	}
+
+	info := &clusterInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return false, err
+	}
+	return info.ClusterInfo.HAState == "ACTIVE", nil
+}
+
+// activeResourceManager returns the cached active ResourceManager or finds the active one among the urls.
+// The ResourceManagers are probed without holding the lock, so a slow probe doesn't block other requests of the client.
+func (c *Client) activeResourceManager(ctx context.Context, urls []string) (string, error) {
+	c.rmMu.Lock()
+	cached := c.activeRM
+	c.rmMu.Unlock()
+
+	for _, rm := range urls {
+		if rm == cached {
+			return rm, nil
+		}
+	}
+
+	var lastErr error
+	for _, rm := range urls {
+		active, err := c.isActive(ctx, rm)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", err
+			}
+			c.debugLog("Error checking ResourceManager %s: %s", rm, err)
+			lastErr = err
+			continue
+		}
+		if active {
+			c.debugLog("Active ResourceManager: %s", rm)
+			c.rmMu.Lock()
+			c.activeRM = rm
+			c.rmMu.Unlock()
+			return rm, nil
+		}
+	}
+
+	if lastErr != nil {
+		return "", lastErr
+	}
+	return "", ErrNoActiveResourceManager
+}
+
+// resetActiveResourceManager forgets the cached active ResourceManager if it's still the failed one.
+func (c *Client) resetActiveResourceManager(rm string) {
+	c.rmMu.Lock()
+	defer c.rmMu.Unlock()
+
+	if c.activeRM == rm {
+		c.activeRM = ""
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	return c.httpClient().Do(req)
+}
+
+// resourceManagerRequest sends the request to the ResourceManager REST API. Redirects of standby ResourceManagers are followed.
+// On high availability clusters requests failing on the active ResourceManager are retried on the ResourceManager which became active.
+// The request and the failover are abandoned when the context is done.
+func (c *Client) resourceManagerRequest(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	e := c.endpoints()
+
+	ha, ok := e.(HAEndpointResolver)
+	if !ok {
+		rm, err := e.ResourceManagerURL()
+		if err != nil {
+			return nil, err
+		}
+		return c.do(ctx, method, rm+path, body)
+	}
+
+	urls, err := ha.ResourceManagerURLs()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; ; i++ {
+		rm, err := c.activeResourceManager(ctx, urls)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.do(ctx, method, rm+path, body)
+		if err == nil && resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("ResourceManager unavailable %s", resp.Status)
+		}
+		// the ResourceManager didn't fail, the caller gave up
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		c.resetActiveResourceManager(rm)
+		if i+1 >= len(urls) {
+			return nil, err
+		}
+		c.debugLog("ResourceManager %s failed, failing over: %s", rm, err)
+	}
+}
@@ -0,0 +1,173 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// testRM is a ResourceManager which is either active or standby. Standby ResourceManagers redirect REST calls to the active one
+// when it's known and reject them otherwise, the same way as YARN does.
+type testRM struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	active   bool
+	redirect string
+	requests []string
+}
+
+func newTestRM(active bool) *testRM {
+	rm := &testRM{active: active}
+	rm.Server = httptest.NewServer(http.HandlerFunc(rm.serve))
+	return rm
+}
+
+func (rm *testRM) set(active bool, redirect string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.active = active
+	rm.redirect = redirect
+}
+
+// appRequests returns the application REST calls handled by the ResourceManager.
+func (rm *testRM) appRequests() []string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.requests
+}
+
+func (rm *testRM) serve(w http.ResponseWriter, r *http.Request) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	state := "STANDBY"
+	if rm.active {
+		state = "ACTIVE"
+	}
+	if r.URL.Path == clusterInfoApiPath {
+		fmt.Fprintf(w, `{"clusterInfo": {"haState": "%s"}}`, state)
+		return
+	}
+
+	if !rm.active {
+		if rm.redirect != "" {
+			http.Redirect(w, r, rm.redirect+r.URL.Path, http.StatusTemporaryRedirect)
+			return
+		}
+		http.Error(w, "This is standby RM", http.StatusServiceUnavailable)
+		return
+	}
+
+	rm.requests = append(rm.requests, r.Method+" "+r.URL.Path)
+	if r.Method == "PUT" {
+		fmt.Fprint(w, `{"state": "KILLED"}`)
+		return
+	}
+	fmt.Fprint(w, `{"app": {"state": "RUNNING", "finalStatus": "UNDEFINED"}}`)
+}
+
+func haClient(rms ...*testRM) *Client {
+	urls := []string{}
+	for _, rm := range rms {
+		urls = append(urls, rm.URL)
+	}
+	return &Client{
+		Provider:  &testProvider{"master"},
+		Endpoints: &HAEndpoints{ResourceManagers: urls},
+		Logger:    log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func TestFailover(t *testing.T) {
+	rm1 := newTestRM(false)
+	defer rm1.Close()
+	rm2 := newTestRM(true)
+	defer rm2.Close()
+
+	hc := runningCommand(haClient(rm1, rm2), "application_1_0001")
+
+	// the second ResourceManager is detected as active
+	if _, err := hc.FetchApplicationStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm1.appRequests()) != 0 || len(rm2.appRequests()) != 1 {
+		t.Fatalf("Invalid requests: %v %v", rm1.appRequests(), rm2.appRequests())
+	}
+
+	// the active ResourceManager becomes standby without knowing the new active one
+	rm2.set(false, "")
+	rm1.set(true, "")
+	if _, err := hc.FetchApplicationStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm1.appRequests()) != 1 {
+		t.Fatalf("Request not failed over: %v", rm1.appRequests())
+	}
+
+	// the active ResourceManager is cached
+	if err := hc.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(rm1.appRequests()) != "[GET /ws/v1/cluster/apps/application_1_0001 PUT /ws/v1/cluster/apps/application_1_0001/state]" {
+		t.Errorf("Invalid requests: %v", rm1.appRequests())
+	}
+}
+
+func TestStandbyRedirect(t *testing.T) {
+	rm1 := newTestRM(true)
+	defer rm1.Close()
+	rm2 := newTestRM(false)
+	defer rm2.Close()
+
+	hc := runningCommand(haClient(rm1, rm2), "application_1_0001")
+	if _, err := hc.FetchApplicationStatus(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cached ResourceManager becomes standby and redirects to the new active one
+	rm1.set(false, rm2.URL)
+	rm2.set(true, "")
+	if _, err := hc.FetchApplicationStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hc.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm1.appRequests()) != 1 || len(rm2.appRequests()) != 2 {
+		t.Errorf("Invalid requests: %v %v", rm1.appRequests(), rm2.appRequests())
+	}
+}
+
+func TestNoActiveResourceManager(t *testing.T) {
+	rm1 := newTestRM(false)
+	defer rm1.Close()
+	rm2 := newTestRM(false)
+	defer rm2.Close()
+
+	if _, err := runningCommand(haClient(rm1, rm2), "application_1_0001").FetchApplicationStatus(); err != ErrNoActiveResourceManager {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	// the active ResourceManager is down
+	rm1.set(true, "")
+	c := haClient(rm1, rm2)
+	if _, err := runningCommand(c, "application_1_0001").FetchApplicationStatus(); err != nil {
+		t.Fatal(err)
+	}
+	rm1.Close()
+	// the probe error of the ResourceManager which is down is returned
+	if _, err := runningCommand(c, "application_1_0001").FetchApplicationStatus(); !isURLError(err) {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func isURLError(err error) bool {
+	_, ok := err.(*url.Error)
+	return ok
+}
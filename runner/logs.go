@@ -24,9 +24,9 @@ type HadoopApplicationLogs struct {
 	ContainerLogs []*HadoopContainerLogs
 }
 
-func newHadoopApplicationLogs(r string) (*HadoopApplicationLogs, error) {
+func newHadoopApplicationLogs(c *Client, r string) (*HadoopApplicationLogs, error) {
 	l := &HadoopApplicationLogs{Raw: r}
-	return l, l.parse()
+	return l, l.parse(c)
 }
 func (l *HadoopApplicationLogs) String() string {
 	if l == nil {
@@ -118,7 +118,8 @@ func (l *HadoopApplicationLogs) AppLog() string {
 	return strings.Join(out, "\n")
 }
 
-func (l *HadoopApplicationLogs) parse() error {
+// parse splits the raw logs by containers. Containers and application log lines are logged by the client.
+func (l *HadoopApplicationLogs) parse(c *Client) error {
 	var container *HadoopContainerLogs
 	logType := ""
 	contents := false
@@ -142,7 +143,7 @@ func (l *HadoopApplicationLogs) parse() error {
 				Container: parts[1],
 				Host:      parts[3],
 			}
-			debugLog("Container: %s on %s", container.Container, container.Host)
+			c.debugLog("Container: %s on %s", container.Container, container.Host)
 		} else if strings.HasPrefix(line, "LogType:") {
 			contents = false
 			logType = strings.TrimPrefix(line, "LogType:")
@@ -159,7 +160,7 @@ func (l *HadoopApplicationLogs) parse() error {
 				if strings.HasPrefix(line, job.MRLogPrefix) {
 					appLog := line[len(job.MRLogPrefix):]
 					container.AppLog += appLog + "\n"
-					debugLog(appLog)
+					c.debugLog("%s", appLog)
 				}
 			} else if logType == "syslog" {
 				container.SysLog += line + "\n"
@@ -2,27 +2,20 @@ package runner
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
-	"net/http"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/Zemanta/mrgob/runner/provider"
+	"github.com/Zemanta/mrgob/job"
 
 	"golang.org/x/crypto/ssh"
 )
 
-var hadoopProvider provider.HadoopProvider
-
-func SetDefaultHadoopProvider(p provider.HadoopProvider) {
-	hadoopProvider = p
-}
-
 type HadoopStatus int
 
 var (
@@ -30,6 +23,8 @@ var (
 	HadoopStatusRunning HadoopStatus = 1
 	HadoopStatusSuccess HadoopStatus = 2
 	HadoopStatusFailed  HadoopStatus = -1
+	// The command was cancelled through its context.
+	HadoopStatusCancelled HadoopStatus = -2
 
 	ErrNotRunning            = fmt.Errorf("Command not running")
 	ErrRunning               = fmt.Errorf("Application running")
@@ -37,6 +32,7 @@ var (
 	ErrMissingApplicationId  = fmt.Errorf("Missing application id")
 	ErrMissingHadoopProvider = fmt.Errorf("Missing Hadoop provider")
 	ErrLoadOverMax           = fmt.Errorf("Load over max load")
+	ErrCancelled             = fmt.Errorf("Command cancelled")
 )
 
 var (
@@ -45,23 +41,26 @@ var (
 )
 
 var (
-	hadoopApiPort     = 8088
-	historyServerPort = 19888
-	statusApiUrl      = "http://%s:%d/ws/v1/cluster/apps/%s"
-	killApiUrl        = "http://%s:%d/ws/v1/cluster/apps/%s/state"
-	killStateBody     = []byte("{\"state\":\"KILLED\"}")
-	counterApiUrl     = "http://%s:%d/ws/v1/history/mapreduce/jobs/%s/counters"
-	yarnLogsCommand   = "yarn logs -applicationId %s"
+	hadoopApiPort      = 8088
+	historyServerPort  = 19888
+	statusApiPath      = "/ws/v1/cluster/apps/%s"
+	killApiPath        = "/ws/v1/cluster/apps/%s/state"
+	killStateBody      = []byte("{\"state\":\"KILLED\"}")
+	clusterInfoApiPath = "/ws/v1/cluster/info"
+	counterApiUrl      = "%s/ws/v1/history/mapreduce/jobs/%s/counters"
+	yarnLogsCommand    = "yarn logs -applicationId %s"
 
 	waitForLogs   = time.Duration(2) * time.Second
 	waitForStatus = time.Duration(5) * time.Second
 
 	retryBackoff = time.Duration(10) * time.Second
+
+	requestTimeout = time.Duration(30) * time.Second
 )
 
 var (
 	maxMasterLoad = 16
-	loadCheck     = fmt.Sprintf(`test $(cat /proc/loadavg | cut -d"." -f 1) -lt %d`, maxMasterLoad)
+	loadCheck     = `test $(cat /proc/loadavg | cut -d"." -f 1) -lt %d`
 )
 
 func debugLog(s string, a ...interface{}) {
@@ -75,7 +74,7 @@ func KillAll() error {
 
 	for hc := range runningJobs {
 		if err := hc.Kill(); err != nil {
-			debugLog("Error killing application: %s", err)
+			hc.client.debugLog("Error killing application: %s", err)
 		}
 	}
 
@@ -83,33 +82,30 @@ func KillAll() error {
 }
 
 type HadoopCommand struct {
+	client *Client
+
 	args    []string
 	retries int
 
 	err       error
 	tries     []*HadoopRun
-	done      sync.Mutex
+	done      chan struct{}
 	started   bool
 	startedMu sync.Mutex
 
 	status HadoopStatus
+
+	description *job.Description
 }
 
+// NewRawMapReduce returns a command running the hadoop command with the arguments on the cluster of the default client.
 func NewRawMapReduce(arguments ...string) *HadoopCommand {
-	hd := &HadoopCommand{
-		args: arguments,
-	}
-	hd.done.Lock()
-	return hd
+	return defaultClient.NewRawMapReduce(arguments...)
 }
 
+// NewMapReduce returns a command running the mapreduce job on the cluster of the default client.
 func NewMapReduce(c *MapReduceConfig) (*HadoopCommand, error) {
-	args, err := c.getArgs()
-	if err != nil {
-		return nil, err
-	}
-
-	return NewRawMapReduce(args...), nil
+	return defaultClient.NewMapReduce(c)
 }
 
 func (hc *HadoopCommand) SetRetries(n int) {
@@ -117,7 +113,13 @@ func (hc *HadoopCommand) SetRetries(n int) {
 }
 
 func (hc *HadoopCommand) Run() HadoopStatus {
-	if hadoopProvider == nil {
+	return hc.RunContext(context.Background())
+}
+
+// RunContext runs the command until it completes or the context is done. Cancelling the context stops polling for the application status,
+// closes the ssh sessions, kills the submitted application and returns HadoopStatusCancelled.
+func (hc *HadoopCommand) RunContext(ctx context.Context) HadoopStatus {
+	if hc.client.Provider == nil {
 		hc.err = ErrMissingHadoopProvider
 		return hc.status
 	}
@@ -125,13 +127,13 @@ func (hc *HadoopCommand) Run() HadoopStatus {
 	hc.startedMu.Lock()
 	if hc.started {
 		hc.startedMu.Unlock()
-		return hc.status
+		return hc.Status()
 	}
 	hc.started = true
 	hc.status = HadoopStatusRunning
 	hc.startedMu.Unlock()
 
-	defer hc.done.Unlock()
+	defer close(hc.done)
 
 	runningJobsMu.Lock()
 	runningJobs[hc] = struct{}{}
@@ -142,6 +144,7 @@ func (hc *HadoopCommand) Run() HadoopStatus {
 		runningJobsMu.Unlock()
 	}()
 
+	status := HadoopStatusFailed
 	for i := 0; i < hc.retries+1; i++ {
 		hr := &HadoopRun{
 			command: hc,
@@ -149,28 +152,46 @@ func (hc *HadoopCommand) Run() HadoopStatus {
 
 		hc.tries = append(hc.tries, hr)
 
-		if ok := hr.exec(hc.args); ok {
-			hc.status = HadoopStatusSuccess
+		if ok := hr.exec(ctx, hc.args); ok {
+			status = HadoopStatusSuccess
 			break
 		}
 
-		time.Sleep(retryBackoff)
+		if i < hc.retries && !sleepContext(ctx, hc.client.retryBackoff()) {
+			break
+		}
 	}
 
-	if hc.status != HadoopStatusSuccess {
-		hc.status = HadoopStatusFailed
+	if status != HadoopStatusSuccess && ctx.Err() != nil {
+		status = HadoopStatusCancelled
 	}
 
-	return hc.status
+	hc.startedMu.Lock()
+	hc.status = status
+	hc.startedMu.Unlock()
+
+	return status
 }
 
 func (hc *HadoopCommand) Wait() HadoopStatus {
-	hc.done.Lock()
-	defer hc.done.Unlock()
-	return hc.status
+	<-hc.done
+	return hc.Status()
+}
+
+// WaitContext waits for the command to complete or the context to be done, in which case the context error is returned
+// and the command keeps running. Cancel the context passed to RunContext to stop the command.
+func (hc *HadoopCommand) WaitContext(ctx context.Context) (HadoopStatus, error) {
+	select {
+	case <-hc.done:
+		return hc.Status(), nil
+	case <-ctx.Done():
+		return hc.Status(), ctx.Err()
+	}
 }
 
 func (hc *HadoopCommand) Status() HadoopStatus {
+	hc.startedMu.Lock()
+	defer hc.startedMu.Unlock()
 	return hc.status
 }
 
@@ -250,8 +271,26 @@ type HadoopRun struct {
 	done time.Time
 }
 
-func (hr *HadoopRun) runCommand(session *ssh.Session, command string) error {
-	debugLog("Running command: `%s`", command)
+// client returns the client of the command.
+func (hr *HadoopRun) client() *Client {
+	return hr.command.client
+}
+
+// sleepContext sleeps for the duration and returns false if the context is done before that.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (hr *HadoopRun) runCommand(ctx context.Context, session *ssh.Session, command string) error {
+	hr.client().debugLog("Running command: `%s`", command)
 
 	// Request pseudo terminal because session.Close doesn't work otherwise
 	modes := ssh.TerminalModes{
@@ -263,6 +302,16 @@ func (hr *HadoopRun) runCommand(session *ssh.Session, command string) error {
 		return err
 	}
 
+	// pipes have to be set up before the command starts
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return err
+	}
+
 	applicationPrefix := "Submitted application "
 	outWg := &sync.WaitGroup{}
 	outWg.Add(2)
@@ -270,15 +319,10 @@ func (hr *HadoopRun) runCommand(session *ssh.Session, command string) error {
 	go func() {
 		defer outWg.Done()
 
-		pipe, err := session.StdoutPipe()
-		if err != nil {
-			return
-		}
-
-		scanner := bufio.NewScanner(pipe)
+		scanner := bufio.NewScanner(stdout)
 		for scanner.Scan() {
 			line := scanner.Text()
-			debugLog(line)
+			hr.client().debugLog("%s", line)
 
 			// find application id
 			if idxStart := strings.Index(line, applicationPrefix); idxStart >= 0 {
@@ -289,7 +333,7 @@ func (hr *HadoopRun) runCommand(session *ssh.Session, command string) error {
 
 				hr.applicationId = strings.TrimSpace(ss)
 				if err := session.Close(); err != nil {
-					debugLog("Error closing ssh session: %s", err)
+					hr.client().debugLog("Error closing ssh session: %s", err)
 				}
 			}
 
@@ -300,15 +344,10 @@ func (hr *HadoopRun) runCommand(session *ssh.Session, command string) error {
 	go func() {
 		defer outWg.Done()
 
-		pipe, err := session.StderrPipe()
-		if err != nil {
-			return
-		}
-
-		scanner := bufio.NewScanner(pipe)
+		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
 			line := scanner.Text()
-			debugLog(line)
+			hr.client().debugLog("%s", line)
 
 			hr.stdOut = append(hr.stdOut, line)
 		}
@@ -317,17 +356,33 @@ func (hr *HadoopRun) runCommand(session *ssh.Session, command string) error {
 	session.Run(command)
 	outWg.Wait()
 
-	return hr.waitForApplicationComplete()
+	if ctx.Err() != nil {
+		return ErrCancelled
+	}
+
+	return hr.waitForApplicationComplete(ctx)
 }
 
-func (hr *HadoopRun) waitForApplicationComplete() error {
+func (hr *HadoopRun) waitForApplicationComplete(ctx context.Context) error {
 	if hr.applicationId == "" {
 		return ErrMissingApplicationId
 	}
 
+	ticker := time.NewTicker(hr.client().statusInterval())
+	defer ticker.Stop()
+
 	retryCount := 0
-	for range time.Tick(waitForStatus) {
-		status, err := hr.FetchApplicationStatus()
+	for {
+		select {
+		case <-ctx.Done():
+			return ErrCancelled
+		case <-ticker.C:
+		}
+
+		status, err := hr.fetchApplicationStatus(ctx)
+		if ctx.Err() != nil {
+			return ErrCancelled
+		}
 		if err != nil {
 			retryCount++
 			if retryCount > 10 {
@@ -345,32 +400,23 @@ func (hr *HadoopRun) waitForApplicationComplete() error {
 		}
 		return fmt.Errorf("Application run error: %s", status.App.FinalStatus)
 	}
-
-	return nil
 }
 
 func (hr *HadoopRun) Kill() error {
+	return hr.kill(context.Background())
+}
+
+func (hr *HadoopRun) kill(ctx context.Context) error {
 	if hr.applicationId == "" {
 		return ErrMissingApplicationId
 	}
-	debugLog("Killing application: %s", hr.applicationId)
+	hr.client().debugLog("Killing application: %s", hr.applicationId)
 
-	hadoopMaster, err := hadoopProvider.GetMasterHost()
-	if err != nil {
-		return err
-	}
-
-	client := &http.Client{}
-	req, err := http.NewRequest("PUT", fmt.Sprintf(killApiUrl, hadoopMaster, hadoopApiPort, hr.applicationId), bytes.NewReader(killStateBody))
-	if err != nil {
-		return err
-	}
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
+	resp, err := hr.client().resourceManagerRequest(ctx, "PUT", fmt.Sprintf(killApiPath, hr.applicationId), killStateBody)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		return fmt.Errorf("Kill application error %s", resp.Status)
@@ -397,9 +443,9 @@ func (hr *HadoopRun) FetchApplicationLogs() (*HadoopApplicationLogs, error) {
 		time.Sleep(time.Now().Sub(hr.done))
 	}
 
-	debugLog("Fetching map reduce application logs")
+	hr.client().debugLog("Fetching map reduce application logs")
 
-	client, err := hadoopProvider.GetMasterSSHClient()
+	client, err := hr.client().masterSSHClient()
 	if err != nil {
 		return nil, err
 	}
@@ -421,7 +467,7 @@ func (hr *HadoopRun) FetchApplicationLogs() (*HadoopApplicationLogs, error) {
 
 		log, runErr = session.Output(command)
 		if runErr != nil {
-			debugLog("Logs not ready yet")
+			hr.client().debugLog("Logs not ready yet")
 			time.Sleep(waitForLogs)
 			continue
 		}
@@ -432,26 +478,29 @@ func (hr *HadoopRun) FetchApplicationLogs() (*HadoopApplicationLogs, error) {
 		return nil, runErr
 	}
 
-	return newHadoopApplicationLogs(string(log))
+	return newHadoopApplicationLogs(hr.client(), string(log))
 }
 
 func (hr *HadoopRun) FetchApplicationStatus() (*HadoopApplicationStatus, error) {
+	return hr.fetchApplicationStatus(context.Background())
+}
+
+func (hr *HadoopRun) fetchApplicationStatus(ctx context.Context) (*HadoopApplicationStatus, error) {
 	if hr.applicationId == "" {
 		return nil, ErrMissingApplicationId
 	}
 
-	debugLog("Fetching map reduce application status")
+	hr.client().debugLog("Fetching map reduce application status")
 
-	hadoopMaster, err := hadoopProvider.GetMasterHost()
+	resp, err := hr.client().resourceManagerRequest(ctx, "GET", fmt.Sprintf(statusApiPath, hr.applicationId), nil)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	resp, err := http.Get(fmt.Sprintf(statusApiUrl, hadoopMaster, hadoopApiPort, hr.applicationId))
-	if err != nil {
-		return nil, err
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("Fetch application status error %s", resp.Status)
 	}
-	defer resp.Body.Close()
 
 	as := &HadoopApplicationStatus{}
 	err = json.NewDecoder(resp.Body).Decode(as)
@@ -459,7 +508,7 @@ func (hr *HadoopRun) FetchApplicationStatus() (*HadoopApplicationStatus, error)
 		return nil, err
 	}
 
-	debugLog("%s: [state: %s, final status: %s, progress: %f, queue: %s, elapsed time: %d]",
+	hr.client().debugLog("%s: [state: %s, final status: %s, progress: %f, queue: %s, elapsed time: %d]",
 		as.App.Name, as.App.State, as.App.FinalStatus, as.App.Progress, as.App.Queue, as.App.ElapsedTime,
 	)
 
@@ -471,21 +520,25 @@ func (hr *HadoopRun) FetchJobCounters() (HadoopJobCounters, error) {
 		return nil, ErrMissingApplicationId
 	}
 
-	debugLog("Fetching map reduce application counters")
+	hr.client().debugLog("Fetching map reduce application counters")
 
-	hadoopMaster, err := hadoopProvider.GetMasterHost()
+	historyServer, err := hr.client().endpoints().HistoryServerURL()
 	if err != nil {
 		return nil, err
 	}
 
 	jobId := strings.Replace(hr.applicationId, "application", "job", 1)
 
-	resp, err := http.Get(fmt.Sprintf(counterApiUrl, hadoopMaster, historyServerPort, jobId))
+	resp, err := hr.client().httpClient().Get(fmt.Sprintf(counterApiUrl, historyServer, jobId))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("Fetch job counters error %s", resp.Status)
+	}
+
 	jc := &hadoopJobCountersRaw{}
 	err = json.NewDecoder(resp.Body).Decode(jc)
 	if err != nil {
@@ -502,7 +555,7 @@ func (hr *HadoopRun) FetchJobCounters() (HadoopJobCounters, error) {
 		counters[group.CounterGroupName] = gr
 	}
 
-	debugLog(counters.AppCounters().String())
+	hr.client().debugLog("%s", counters.AppCounters().String())
 
 	return counters, nil
 }
@@ -514,18 +567,18 @@ func (hr *HadoopRun) checkServerLoad(client *ssh.Client) error {
 	}
 	defer session.Close()
 
-	err = session.Run(loadCheck)
+	err = session.Run(hr.client().loadCheck())
 	if err != nil {
 		return ErrLoadOverMax
 	}
 	return nil
 }
 
-func (hr *HadoopRun) checkAndWaitServerLoad(client *ssh.Client) error {
+func (hr *HadoopRun) checkAndWaitServerLoad(ctx context.Context, client *ssh.Client) error {
 	var err error
 	for i := 0; i < 10; i++ {
-		if i > 0 {
-			time.Sleep(time.Duration(10.0+30.0*rand.Float64()) * time.Second)
+		if i > 0 && !sleepContext(ctx, time.Duration(10.0+30.0*rand.Float64())*time.Second) {
+			return ErrCancelled
 		}
 		err = hr.checkServerLoad(client)
 		if err == nil {
@@ -535,17 +588,33 @@ func (hr *HadoopRun) checkAndWaitServerLoad(client *ssh.Client) error {
 	return err
 }
 
-func (hr *HadoopRun) exec(arguments []string) bool {
+func (hr *HadoopRun) exec(ctx context.Context, arguments []string) (ok bool) {
 	defer func() { hr.done = time.Now() }()
 
-	client, err := hadoopProvider.GetMasterSSHClient()
+	client, err := hr.client().masterSSHClient()
 	if err != nil {
 		hr.err = err
 		return false
 	}
 	defer client.Close()
 
-	if err := hr.checkAndWaitServerLoad(client); err != nil {
+	// closing the client on cancellation closes its sessions and unblocks running commands
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-stop:
+		}
+	}()
+	defer func() {
+		if !ok {
+			hr.killCancelled(ctx)
+		}
+	}()
+
+	if err := hr.checkAndWaitServerLoad(ctx, client); err != nil {
 		hr.err = err
 		return false
 	}
@@ -558,7 +627,7 @@ func (hr *HadoopRun) exec(arguments []string) bool {
 	defer session.Close()
 
 	command := "\"" + strings.Join(arguments, `" "`) + "\""
-	err = hr.runCommand(session, command)
+	err = hr.runCommand(ctx, session, command)
 	if err != nil {
 		hr.err = err
 		return false
@@ -572,6 +641,25 @@ func (hr *HadoopRun) exec(arguments []string) bool {
 	return true
 }
 
+// killCancelled kills the submitted application of a failed run if the context was cancelled.
+func (hr *HadoopRun) killCancelled(ctx context.Context) {
+	if ctx.Err() == nil {
+		return
+	}
+	hr.err = ErrCancelled
+
+	if hr.applicationId == "" {
+		return
+	}
+
+	// the run context is done, the kill gets its own
+	killCtx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := hr.kill(killCtx); err != nil {
+		hr.client().debugLog("Error killing cancelled application: %s", err)
+	}
+}
+
 func (hr *HadoopRun) CmdOutput() (stdOut string, stdErr string, cmdErr error) {
 	return strings.Join(hr.stdOut, "\n"), strings.Join(hr.stdErr, "\n"), hr.err
 }
@@ -583,6 +671,10 @@ func (hr *HadoopRun) FetchDebugData() (*HadoopDebugData, error) {
 
 	d.StdOut, d.StdErr, d.CmdErr = hr.CmdOutput()
 
+	if hr.command != nil && hr.command.description != nil {
+		d.JobBuild = hr.command.description.Build
+	}
+
 	d.Logs, _ = hr.FetchApplicationLogs()
 	d.Counters, _ = hr.FetchJobCounters()
 	d.Status, _ = hr.FetchApplicationStatus()
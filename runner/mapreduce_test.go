@@ -0,0 +1,193 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCluster submits applications over ssh and reports them as running until they're killed. Status requests of a hanging cluster
+// never return.
+type testCluster struct {
+	ssh *sshServer
+	rm  *httptest.Server
+
+	mu       sync.Mutex
+	hang     bool
+	polls    int
+	kills    []string
+	polled   chan struct{}
+	oncePoll sync.Once
+}
+
+func newTestCluster(t *testing.T) *testCluster {
+	c := &testCluster{polled: make(chan struct{})}
+
+	c.ssh = newSSHServer(t)
+	c.ssh.exec = func(command string, out io.Writer) uint32 {
+		if !strings.HasPrefix(command, "test ") {
+			fmt.Fprintf(out, "Submitted application application_1_0001\n")
+		}
+		return 0
+	}
+
+	c.rm = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		if r.Method == "PUT" {
+			c.kills = append(c.kills, r.URL.Path)
+			c.mu.Unlock()
+			fmt.Fprint(w, `{"state": "KILLED"}`)
+			return
+		}
+		c.polls++
+		hang := c.hang
+		c.mu.Unlock()
+
+		c.oncePoll.Do(func() { close(c.polled) })
+		if hang {
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, `{"app": {"state": "RUNNING", "finalStatus": "UNDEFINED"}}`)
+	}))
+	return c
+}
+
+func (c *testCluster) Close() {
+	c.rm.Close()
+	c.ssh.Close()
+}
+
+func (c *testCluster) client() *Client {
+	return &Client{
+		Provider:       &sshProvider{c.ssh.Addr().String()},
+		Endpoints:      &StaticEndpoints{ResourceManager: c.rm.URL},
+		Logger:         log.New(ioutil.Discard, "", 0),
+		StatusInterval: 10 * time.Millisecond,
+	}
+}
+
+func (c *testCluster) killed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.kills
+}
+
+func TestRunContextCancel(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+
+	hc := cluster.client().NewRawMapReduce("hadoop-streaming")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-cluster.polled
+		cancel()
+	}()
+
+	if status := hc.RunContext(ctx); status != HadoopStatusCancelled {
+		t.Errorf("Invalid status: %d", status)
+	}
+	if hc.Wait() != HadoopStatusCancelled {
+		t.Errorf("Invalid status after wait: %d", hc.Wait())
+	}
+
+	if fmt.Sprint(cluster.killed()) != "[/ws/v1/cluster/apps/application_1_0001/state]" {
+		t.Errorf("Application not killed: %v", cluster.killed())
+	}
+	if _, _, err := hc.CmdOutput(); err != ErrCancelled {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestRunContextCancelHangingPoll(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+	cluster.mu.Lock()
+	cluster.hang = true
+	cluster.mu.Unlock()
+
+	hc := cluster.client().NewRawMapReduce("hadoop-streaming")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-cluster.polled
+		cancel()
+	}()
+
+	// the status request is abandoned instead of waiting for the request timeout
+	start := time.Now()
+	if status := hc.RunContext(ctx); status != HadoopStatusCancelled {
+		t.Errorf("Invalid status: %d", status)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Cancellation waited for the status request: %s", time.Since(start))
+	}
+	if len(cluster.killed()) != 1 {
+		t.Errorf("Application not killed: %v", cluster.killed())
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	cluster := newTestCluster(t)
+	defer cluster.Close()
+
+	hc := cluster.client().NewRawMapReduce("hadoop-streaming")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hc.RunContext(ctx)
+	<-cluster.polled
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer waitCancel()
+	status, err := hc.WaitContext(waitCtx)
+	if err != context.DeadlineExceeded || status != HadoopStatusRunning {
+		t.Errorf("Invalid wait result: %d %v", status, err)
+	}
+	if len(cluster.killed()) != 0 {
+		t.Errorf("Application killed by the wait: %v", cluster.killed())
+	}
+
+	cancel()
+	if status, err := hc.WaitContext(context.Background()); err != nil || status != HadoopStatusCancelled {
+		t.Errorf("Invalid wait result: %d %v", status, err)
+	}
+}
+
+func TestRetryBackoffCancel(t *testing.T) {
+	// ssh connections of the test provider fail
+	c := &Client{
+		Provider:     &testProvider{"master"},
+		Logger:       log.New(ioutil.Discard, "", 0),
+		RetryBackoff: time.Hour,
+	}
+	hc := c.NewRawMapReduce("hadoop-streaming")
+	hc.SetRetries(3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if status := hc.RunContext(ctx); status != HadoopStatusCancelled {
+		t.Errorf("Invalid status: %d", status)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Backoff not stopped: %s", time.Since(start))
+	}
+	if len(hc.Tries()) != 1 {
+		t.Errorf("Invalid number of tries: %d", len(hc.Tries()))
+	}
+
+	if sleepContext(ctx, time.Hour) {
+		t.Errorf("Sleep not stopped by the done context")
+	}
+	if !sleepContext(context.Background(), time.Millisecond) {
+		t.Errorf("Sleep stopped without cancellation")
+	}
+}
@@ -3,6 +3,7 @@ package runner
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Zemanta/mrgob/job"
 )
@@ -64,6 +65,29 @@ func (c HadoopJobCounters) AppCounters() HadoopJobCountersGroup {
 	return c[job.AppCounterGroup]
 }
 
+// StatsCounters returns throughput and timing counters reported by instrumented jobs.
+func (c HadoopJobCounters) StatsCounters() HadoopJobCountersGroup {
+	return c[job.StatsCounterGroup]
+}
+
+// StageStats aggregates throughput and timing counters of all the tasks of a stage (mapper, combiner or reducer).
+func (c HadoopJobCounters) StageStats(stage string) *HadoopStageStats {
+	g := c.StatsCounters()
+	v := func(name string) int {
+		return g[stage+"_"+name].TotalCounterValue
+	}
+
+	return &HadoopStageStats{
+		RecordsIn:    v(job.StatRecordsIn),
+		RecordsOut:   v(job.StatRecordsOut),
+		BytesIn:      v(job.StatBytesIn),
+		BytesOut:     v(job.StatBytesOut),
+		UserTime:     time.Duration(v(job.StatUserMs)) * time.Millisecond,
+		IOTime:       time.Duration(v(job.StatIOMs)) * time.Millisecond,
+		DecodeErrors: v(job.StatDecodeErrors),
+	}
+}
+
 type HadoopJobCountersGroup map[string]HadoopJobCounterData
 
 func (c HadoopJobCountersGroup) String() string {
@@ -74,11 +98,55 @@ func (c HadoopJobCountersGroup) String() string {
 	return "[" + strings.Join(o, ", ") + "]"
 }
 
+// HadoopStageStats holds throughput and timing of a stage, summed over all of its tasks.
+type HadoopStageStats struct {
+	RecordsIn  int
+	RecordsOut int
+	BytesIn    int
+	BytesOut   int
+
+	// Time spent in the job code and in reading the input and writing the output.
+	UserTime time.Duration
+	IOTime   time.Duration
+
+	DecodeErrors int
+}
+
+// TaskTime returns the total time spent in all the tasks of the stage.
+func (s *HadoopStageStats) TaskTime() time.Duration {
+	return s.UserTime + s.IOTime
+}
+
+// RecordsPerSecond returns the number of input records processed per second of task time.
+func (s *HadoopStageStats) RecordsPerSecond() float64 {
+	if s.TaskTime() == 0 {
+		return 0
+	}
+	return float64(s.RecordsIn) / s.TaskTime().Seconds()
+}
+
+// BytesPerSecond returns the number of input bytes processed per second of task time.
+func (s *HadoopStageStats) BytesPerSecond() float64 {
+	if s.TaskTime() == 0 {
+		return 0
+	}
+	return float64(s.BytesIn) / s.TaskTime().Seconds()
+}
+
+func (s *HadoopStageStats) String() string {
+	return fmt.Sprintf("[records in: %d, records out: %d, bytes in: %d, bytes out: %d, user time: %s, io time: %s, decode errors: %d, records/s: %.1f]",
+		s.RecordsIn, s.RecordsOut, s.BytesIn, s.BytesOut, s.UserTime, s.IOTime, s.DecodeErrors, s.RecordsPerSecond(),
+	)
+}
+
 type HadoopDebugData struct {
 	Logs     *HadoopApplicationLogs
 	Counters HadoopJobCounters
 	Status   *HadoopApplicationStatus
 
+	// Build info of the job executable, set when the command was created with a job description.
+	JobBuild *job.BuildDescription
+
 	StdOut string
 	StdErr string
 	CmdErr error
@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Zemanta/mrgob/job"
+)
+
+func TestStageStats(t *testing.T) {
+	counters := HadoopJobCounters{
+		job.StatsCounterGroup: {
+			"mapper_records_in":     {TotalCounterValue: 3000},
+			"mapper_records_out":    {TotalCounterValue: 6000},
+			"mapper_bytes_in":       {TotalCounterValue: 1000000},
+			"mapper_bytes_out":      {TotalCounterValue: 2000000},
+			"mapper_user_ms":        {TotalCounterValue: 1500},
+			"mapper_io_ms":          {TotalCounterValue: 500},
+			"mapper_decode_errors":  {TotalCounterValue: 2},
+			"reducer_records_in":    {TotalCounterValue: 6000},
+			"reducer_decode_errors": {TotalCounterValue: 1},
+		},
+	}
+
+	s := counters.StageStats("mapper")
+	expected := &HadoopStageStats{
+		RecordsIn:    3000,
+		RecordsOut:   6000,
+		BytesIn:      1000000,
+		BytesOut:     2000000,
+		UserTime:     1500 * time.Millisecond,
+		IOTime:       500 * time.Millisecond,
+		DecodeErrors: 2,
+	}
+	if *s != *expected {
+		t.Errorf("Invalid mapper stats: %s != %s", s, expected)
+	}
+	if s.TaskTime() != 2*time.Second || s.RecordsPerSecond() != 1500 || s.BytesPerSecond() != 500000 {
+		t.Errorf("Invalid mapper throughput: %s %f %f", s.TaskTime(), s.RecordsPerSecond(), s.BytesPerSecond())
+	}
+
+	if s := counters.StageStats("reducer"); s.RecordsIn != 6000 || s.DecodeErrors != 1 || s.RecordsPerSecond() != 0 {
+		t.Errorf("Invalid reducer stats: %s", s)
+	}
+	if s := (HadoopJobCounters{}).StageStats("combiner"); *s != (HadoopStageStats{}) {
+		t.Errorf("Invalid stats without counters: %s", s)
+	}
+}
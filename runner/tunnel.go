@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/Zemanta/mrgob/runner/provider"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHTunnel dials connections through the ssh client of the provider, so REST APIs on the cluster's private network can be called
+// from anywhere the master is reachable over ssh. A single ssh connection is shared by all the dialed connections and reconnected when it fails.
+type SSHTunnel struct {
+	provider provider.HadoopProvider
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// NewSSHTunnel returns a tunnel through the ssh client of the provider.
+func NewSSHTunnel(p provider.HadoopProvider) *SSHTunnel {
+	return &SSHTunnel{provider: p}
+}
+
+func (t *SSHTunnel) sshClient() (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+	if t.provider == nil {
+		return nil, ErrMissingHadoopProvider
+	}
+
+	client, err := t.provider.GetMasterSSHClient()
+	if err != nil {
+		return nil, err
+	}
+	t.client = client
+	return client, nil
+}
+
+// reset closes the ssh client if it's still the failed one.
+func (t *SSHTunnel) reset(client *ssh.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client == client {
+		t.client.Close()
+		t.client = nil
+	}
+}
+
+// Dial opens a connection to the address as seen from the master.
+func (t *SSHTunnel) Dial(network, addr string) (net.Conn, error) {
+	return t.DialContext(context.Background(), network, addr)
+}
+
+// DialContext opens a connection to the address as seen from the master. The dial is abandoned when the context is done.
+func (t *SSHTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := t.sshClient()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, network, addr)
+	if err == nil {
+		return conn, nil
+	}
+
+	// the caller gave up or the master rejected the target, the ssh connection and its other channels are fine
+	var openErr *ssh.OpenChannelError
+	if ctx.Err() != nil || errors.As(err, &openErr) {
+		return nil, err
+	}
+
+	// the ssh connection may have been dropped
+	t.reset(client)
+	if client, err = t.sshClient(); err != nil {
+		return nil, err
+	}
+	return client.DialContext(ctx, network, addr)
+}
+
+// Transport returns an http transport dialing through the tunnel. Idle connections are kept open and reused.
+func (t *SSHTunnel) Transport(tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = t.DialContext
+	transport.TLSClientConfig = tlsConfig
+	return transport
+}
+
+// Close closes the ssh client of the tunnel.
+func (t *SSHTunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client == nil {
+		return nil
+	}
+	err := t.client.Close()
+	t.client = nil
+	return err
+}
@@ -0,0 +1,297 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// privateHost is only resolvable through the test ssh server.
+const privateHost = "master.internal"
+
+// Channels to hungHost are never answered by the test ssh server.
+const hungHost = "hung.internal"
+
+// sshServer forwards direct-tcpip channels the same way as sshd does for ssh -L. Commands of sessions are handled by exec.
+type sshServer struct {
+	net.Listener
+	config *ssh.ServerConfig
+	exec   func(command string, out io.Writer) uint32
+
+	mu       sync.Mutex
+	conns    int
+	channels int
+}
+
+func newSSHServer(t *testing.T) *sshServer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &sshServer{config: &ssh.ServerConfig{NoClientAuth: true}}
+	s.config.AddHostKey(signer)
+
+	if s.Listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := s.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *sshServer) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, s.channels
+}
+
+func (s *sshServer) serve(c net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	s.mu.Lock()
+	s.conns++
+	s.mu.Unlock()
+
+	for nc := range chans {
+		if nc.ChannelType() == "session" && s.exec != nil {
+			go s.session(nc)
+			continue
+		}
+		if nc.ChannelType() != "direct-tcpip" {
+			nc.Reject(ssh.UnknownChannelType, nc.ChannelType())
+			continue
+		}
+
+		var dest struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(nc.ExtraData(), &dest); err != nil {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		if dest.Host == hungHost {
+			continue
+		}
+		if dest.Host == privateHost {
+			dest.Host = "127.0.0.1"
+		}
+
+		target, err := net.Dial("tcp", net.JoinHostPort(dest.Host, strconv.Itoa(int(dest.Port))))
+		if err != nil {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			target.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+
+		s.mu.Lock()
+		s.channels++
+		s.mu.Unlock()
+
+		go func() {
+			io.Copy(ch, target)
+			ch.Close()
+		}()
+		go func() {
+			io.Copy(target, ch)
+			target.Close()
+		}()
+	}
+}
+
+// session runs the command of the session and reports its exit status.
+func (s *sshServer) session(nc ssh.NewChannel) {
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			req.Reply(true, nil)
+		case "exec":
+			var cmd struct {
+				Command string
+			}
+			ssh.Unmarshal(req.Payload, &cmd)
+			req.Reply(true, nil)
+
+			status := s.exec(cmd.Command, ch)
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+type sshProvider struct {
+	addr string
+}
+
+func (p *sshProvider) GetMasterHost() (string, error) {
+	return privateHost, nil
+}
+
+func (p *sshProvider) GetMasterSSHClient() (*ssh.Client, error) {
+	return ssh.Dial("tcp", p.addr, &ssh.ClientConfig{User: "hadoop", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+}
+
+func TestSSHTunnel(t *testing.T) {
+	rm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"app": {"finalStatus": "SUCCEEDED"}}`)
+	}))
+	defer rm.Close()
+	_, port, _ := net.SplitHostPort(rm.Listener.Addr().String())
+
+	s := newSSHServer(t)
+	defer s.Close()
+
+	c := &Client{
+		Provider:  &sshProvider{s.Addr().String()},
+		Endpoints: &StaticEndpoints{ResourceManager: "http://" + net.JoinHostPort(privateHost, port)},
+		Tunnel:    true,
+		Logger:    log.New(ioutil.Discard, "", 0),
+	}
+	defer c.Close()
+
+	hc := runningCommand(c, "application_1_0001")
+	for i := 0; i < 3; i++ {
+		status, err := hc.FetchApplicationStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.App.FinalStatus != "SUCCEEDED" {
+			t.Errorf("Invalid status: %s", status.App.FinalStatus)
+		}
+	}
+
+	// the ssh connection and the http connection are reused
+	if conns, channels := s.counts(); conns != 1 || channels != 1 {
+		t.Errorf("Connections not reused: %d ssh connections, %d channels", conns, channels)
+	}
+
+	// the tunnel reconnects after it's closed
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.FetchApplicationStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if conns, channels := s.counts(); conns != 2 || channels != 2 {
+		t.Errorf("Tunnel not reconnected: %d ssh connections, %d channels", conns, channels)
+	}
+}
+
+func TestSSHTunnelRefused(t *testing.T) {
+	rm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"app": {"finalStatus": "SUCCEEDED"}}`)
+	}))
+	defer rm.Close()
+	_, port, _ := net.SplitHostPort(rm.Listener.Addr().String())
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+
+	s := newSSHServer(t)
+	defer s.Close()
+
+	tunnel := NewSSHTunnel(&sshProvider{s.Addr().String()})
+	defer tunnel.Close()
+
+	conn, err := tunnel.Dial("tcp", net.JoinHostPort(privateHost, port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := tunnel.Dial("tcp", net.JoinHostPort(privateHost, closedPort)); err == nil {
+		t.Fatal("Dial of a refused target succeeded")
+	}
+
+	// the refused target doesn't reset the ssh connection of the open one
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", privateHost)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Invalid status: %s", resp.Status)
+	}
+	if conns, _ := s.counts(); conns != 1 {
+		t.Errorf("Ssh connection reset: %d ssh connections", conns)
+	}
+}
+
+func TestSSHTunnelDialContext(t *testing.T) {
+	s := newSSHServer(t)
+	defer s.Close()
+
+	tunnel := NewSSHTunnel(&sshProvider{s.Addr().String()})
+	defer tunnel.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := tunnel.DialContext(ctx, "tcp", net.JoinHostPort(hungHost, "80"))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Invalid error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dial not abandoned after the context is done")
+	}
+	if conns, _ := s.counts(); conns != 1 {
+		t.Errorf("Ssh connection reset: %d ssh connections", conns)
+	}
+}